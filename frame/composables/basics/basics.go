@@ -1,7 +1,9 @@
 package basics
 
 import(
+	"github.com/opesun/chill/frame/filter"
 	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/query"
 	"labix.org/v2/mgo/bson"
 )
 
@@ -14,6 +16,13 @@ type QueryInfo struct {
 	Skipped	int
 	Limited	int
 	Sorted	[]string
+	Prev	string		// Cursors of the neighbouring pages, empty if there is no such page.
+	Next	string
+}
+
+// The read only verbs, see glue.AllowedMethod. The modules embedding Basics append their own ones to these.
+func (b *Basics) SafeVerbs() []string {
+	return []string{"Get", "GetSingle", "Aggregate"}
 }
 
 func (b *Basics) Get(a iface.Filter) ([]interface{}, *QueryInfo, error) {
@@ -25,13 +34,44 @@ func (b *Basics) Get(a iface.Filter) ([]interface{}, *QueryInfo, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	prev, next := a.Cursors()
 	return list, &QueryInfo{
 		count, a.Modifiers().Skip(),
 		a.Modifiers().Limit(),
 		a.Modifiers().Sort(),
+		prev, next,
 	}, nil
 }
 
+// Columns of an aggregation result, in the order they should be displayed: the group fields first, then the operations.
+type AggregateInfo struct {
+	Columns	[]string
+}
+
+// Aggregate groups the documents matching a, and computes counts, sums, averages, minimums, maximums or the distinct values
+// of fields for every group, see filter.ParseAggregation for the format of spec. Eg.
+//	/posts/aggregate?1by=author&1ops=count&sort=-count&limit=10
+// The verb needs an input scheme like any other, eg.
+//	"Aggregate": {"input": {"by": {"type": "string", "slice": true}, "ops": {"type": "string", "slice": true}}}
+func (b *Basics) Aggregate(a iface.Filter, spec map[string]interface{}) ([]map[string]interface{}, *AggregateInfo, error) {
+	agg, err := filter.ParseAggregation(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := a.Aggregate(agg)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &AggregateInfo{}
+	for _, v := range agg.By {
+		info.Columns = append(info.Columns, query.Column(v.Field))
+	}
+	for _, v := range agg.Ops {
+		info.Columns = append(info.Columns, query.OpName(v))
+	}
+	return rows, info, nil
+}
+
 func (b *Basics) GetSingle(a iface.Filter) (map[string]interface{}, error) {
 	return a.FindOne()
 }
@@ -47,7 +87,7 @@ func (b *Basics) Insert(a iface.Filter, data map[string]interface{}) (bson.Objec
 		q := map[string]interface{}{
 			"_id": id,
 		}
-		filt := a.Clone().AddQuery(q)
+		filt := a.AddQuery(q)
 		b.Ev.Fire("Inserted", filt)
 		b.Ev.Fire(a.Subject() + "Inserted", filt)
 	}
@@ -0,0 +1,99 @@
+// Package computed fills the fields of the input data which are assigned by the server and not by the client.
+// They are declared per verb, next to the input scheme:
+//	"Insert": {
+//		"input": {"title": 1},
+//		"computed": {
+//			"created_at":	"now",
+//			"created_by":	"user",
+//			"slug":			{"slug": "title"},
+//			"status":		{"value": "draft"},
+//			"views":		{"default": 0}
+//		}
+//	}
+// "now" is the current unix timestamp, "user" is the id of the current user (the field is left out for guests).
+// A slug is derived from an other field of the data, if that is present. A "value" is always set, a "default" only if the field is missing.
+// Since the computed fields are applied after the sanitization, the client can't forge them.
+package computed
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/slugify"
+	"sort"
+	"time"
+)
+
+// Checks a single computed field declaration.
+func Check(spec interface{}) error {
+	switch s := spec.(type) {
+	case string:
+		if s != "now" && s != "user" {
+			return fmt.Errorf("Unkown computed value %v, must be \"now\" or \"user\".", s)
+		}
+		return nil
+	case map[string]interface{}:
+		if len(s) != 1 {
+			return fmt.Errorf("Must have exactly one of the keys slug, value or default.")
+		}
+		if src, has := s["slug"]; has {
+			if _, ok := src.(string); !ok {
+				return fmt.Errorf("Slug must name the field it is derived from.")
+			}
+			return nil
+		}
+		_, has_val := s["value"]
+		_, has_def := s["default"]
+		if !has_val && !has_def {
+			return fmt.Errorf("Must have exactly one of the keys slug, value or default.")
+		}
+		return nil
+	}
+	return fmt.Errorf("Must be a string or a map.")
+}
+
+func userId(user map[string]interface{}) (interface{}, bool) {
+	if user == nil {
+		return nil, false
+	}
+	id, has := user["_id"]
+	return id, has && id != nil
+}
+
+// Apply sets the computed fields declared in specs on data. user is the current user, see uni.Dat["_user"].
+func Apply(specs map[string]interface{}, data, user map[string]interface{}) error {
+	keys := []string{}
+	for i := range specs {
+		keys = append(keys, i)
+	}
+	sort.Strings(keys)		// Slugs should not depend on the order of the map iteration.
+	for _, field := range keys {
+		spec := specs[field]
+		if err := Check(spec); err != nil {
+			return errs.New(errs.Internal, "Computed field %v is misconfigured: %v", field, err)
+		}
+		switch s := spec.(type) {
+		case string:
+			switch s {
+			case "now":
+				data[field] = time.Now().Unix()
+			case "user":
+				if id, ok := userId(user); ok {
+					data[field] = id
+				} else {
+					delete(data, field)
+				}
+			}
+		case map[string]interface{}:
+			if src, has := s["slug"]; has {
+				if val, ok := data[src.(string)]; ok && val != nil {
+					data[field] = slugify.S(fmt.Sprint(val))
+				}
+			} else if val, has := s["value"]; has {
+				data[field] = val
+			} else if _, has := data[field]; !has {
+				data[field] = s["default"]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package computed_test
+
+import(
+	"github.com/opesun/chill/frame/computed"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	specs := map[string]interface{}{
+		"created_at":	"now",
+		"created_by":	"user",
+		"slug":			map[string]interface{}{"slug": "title"},
+		"status":		map[string]interface{}{"value": "draft"},
+		"views":		map[string]interface{}{"default": 0},
+		"color":		map[string]interface{}{"default": "red"},
+	}
+	data := map[string]interface{}{
+		"title":		"Hello",
+		"created_at":	1,					// Forged.
+		"status":		"published",		// Forged.
+		"color":		"blue",
+	}
+	err := computed.Apply(specs, data, map[string]interface{}{"_id": "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["created_at"].(int64) <= 1 || data["created_by"] != "u1" || data["slug"] == nil {
+		t.Fatal(data)
+	}
+	if data["status"] != "draft" || data["views"] != 0 || data["color"] != "blue" {
+		t.Fatal(data)
+	}
+}
+
+func TestGuest(t *testing.T) {
+	data := map[string]interface{}{
+		"created_by":	"u2",
+	}
+	err := computed.Apply(map[string]interface{}{"created_by": "user", "slug": map[string]interface{}{"slug": "title"}}, data, map[string]interface{}{"level": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, has := data["created_by"]; has {
+		t.Fatal(data)
+	}
+	if _, has := data["slug"]; has {
+		t.Fatal(data)
+	}
+}
+
+func TestMisconfigured(t *testing.T) {
+	err := computed.Apply(map[string]interface{}{"a": "tomorrow"}, map[string]interface{}{}, nil)
+	if err == nil {
+		t.Fatal()
+	}
+}
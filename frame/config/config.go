@@ -23,6 +23,11 @@ type Config struct{
 	CacheOpt	bool
 	ServeFiles	bool
 	Secret		string
+	DBBackend	string
+	SQLitePath	string
+	DBSocketTimeout	int
+	DBSyncTimeout	int
+	TenantMode		string
 }
 
 var cli = Config{}
@@ -84,6 +89,21 @@ func (c *Config) LoadFromFile() {
 	if secret, ok := conf["secret"].(string); ok {
 		c.Secret = secret
 	}
+	if db_backend, ok := conf["db_backend"].(string); ok {
+		c.DBBackend = db_backend
+	}
+	if sqlite_path, ok := conf["sqlite_path"].(string); ok {
+		c.SQLitePath = sqlite_path
+	}
+	if socket_timeout, ok := conf["db_socket_timeout"].(float64); ok {
+		c.DBSocketTimeout = int(socket_timeout)
+	}
+	if sync_timeout, ok := conf["db_sync_timeout"].(float64); ok {
+		c.DBSyncTimeout = int(sync_timeout)
+	}
+	if tenant_mode, ok := conf["tenant_mode"].(string); ok {
+		c.TenantMode = tenant_mode
+	}
 }
 
 func args() {
@@ -100,5 +120,10 @@ func args() {
 	flag.BoolVar(	&cli.CacheOpt, 		"cache_opt", 	false, 				"cache option document")
 	flag.BoolVar(	&cli.ServeFiles, 	"serve_files", 	true, 				"serve files from Go or not")
 	flag.StringVar(	&cli.Secret, 		"secret", 		"pLsCh4nG3Th1$.AlSoThisShouldbeatLeast16bytes", "secret characters used for encryption and the like")
+	flag.StringVar(	&cli.DBBackend, 	"db_backend", 	"mongodb", 			"storage driver: mongodb, sqlite or memory")
+	flag.StringVar(	&cli.SQLitePath, 	"sqlite_path", 	"chill.db", 		"database file of the sqlite backend, relative to abs_path")
+	flag.IntVar(	&cli.DBSocketTimeout, "db_socket_timeout", 60, 			"seconds to wait for a database socket operation")
+	flag.IntVar(	&cli.DBSyncTimeout, "db_sync_timeout", 	10, 				"seconds to wait for a usable database server")
+	flag.StringVar(	&cli.TenantMode, 	"tenant_mode", 	"", 				"serving multiple sites: empty for one site only, db for a database per site, prefix for collection prefixes")
 	flag.Parse()
 }
\ No newline at end of file
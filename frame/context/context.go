@@ -4,15 +4,15 @@ package context
 import (
 	iface "github.com/opesun/chill/frame/interfaces"
 	"github.com/opesun/chill/frame/lang"
-	"labix.org/v2/mgo"
 	"net/http"
 )
 
 // General context for the application.
 type Uni struct {
 	Modifiers			map[string]interface{}
-	Session 			*mgo.Session
-	Db      			*mgo.Database
+	Storage				iface.Storage				// The database of the site, see package storage.
+	MainStorage			iface.Storage				// The main database, the sites are registered there, see package sites.
+	DefaultSite			bool						// The request is served by the default site.
 	W       			http.ResponseWriter
 	Req     			*http.Request
 	secret  			string                 		// Used for things like encryption/decryption. Basically a permanent random data.
@@ -271,6 +271,31 @@ func pager(uni *context.Uni, pagestr string, count, limit int) []paging.Pelem {
 	return nav
 }
 
+type cursorNav struct {
+	Prev	string		// URLs of the neighbouring pages, empty if there is no such page.
+	Next	string
+}
+
+// Builds the page links from the cursors of a Get, see QueryInfo.
+// The other query parameters are kept, except the ones of the skip based paging.
+func cursors(uni *context.Uni, prev, next string) *cursorNav {
+	link := func(key, cursor string) string {
+		if cursor == "" {
+			return ""
+		}
+		q := uni.Req.URL.Query()
+		for _, v := range []string{"after", "before", "page", "skip"} {
+			q.Del(v)
+		}
+		q.Set(key, cursor)
+		return uni.Path + "?" + q.Encode()
+	}
+	return &cursorNav{
+		link("before", prev),
+		link("after", next),
+	}
+}
+
 func elem(s []interface{}, memb int) interface{} {
 	return s[memb]
 }
@@ -336,6 +361,9 @@ func builtins(uni *context.Uni) map[string]interface{} {
 			}
 			return pager(uni, pagestr, count, limited)
 		},
+		"cursors": func(prev, next string) *cursorNav {
+			return cursors(uni, prev, next)
+		},
 	}
 	uni.Ev.Fire("AddTemplateBuiltin", ret)
 	return ret
@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/opesun/chill/frame/context"
+	"github.com/opesun/chill/frame/errs"
 	"github.com/opesun/chill/frame/misc/scut"
 	"github.com/opesun/chill/frame/misc/convert"
 	"github.com/opesun/chill/frame/display/model"
@@ -16,6 +17,8 @@ import (
 	"github.com/opesun/require"
 	"github.com/russross/blackfriday"
 	"html/template"
+	"net/http"
+	"strconv"
 	"runtime/debug"
 	"strings"
 	"path/filepath"
@@ -202,15 +205,48 @@ func putJSON(uni *context.Uni) {
 	uni.Put(string(v))
 }
 
-// This is called if an error occured in a front hook.
+// Answers a request which ended with an error, with the status code matching the kind of the error (see package errs).
+// JSON clients get an error envelope, the others the template file named after the status code (eg. "404"), or the "error" one.
 func DErr(uni *context.Uni, err error) {
-	if _, isjson := uni.Req.Form["json"]; isjson {
-		putJSON(uni)
+	id := errs.RequestId(uni.Req)
+	status := errs.Status(err)
+	if status >= 500 {
+		// The details of the failure are in the log and in errs.Recent (see top.Report), they are not for the client.
+		err = errs.New(errs.Internal, "Internal server error, request id: %v.", id)
+	}
+	env := errs.Envelope(err)
+	env["error"].(map[string]interface{})["request_id"] = id
+	if WantsJSON(uni) {
+		v, _ := json.Marshal(env)
+		uni.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+		uni.W.WriteHeader(status)
+		uni.Put(string(v))
 		return
 	}
+	uni.Dat["error"] = env["error"]
+	uni.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+	uni.W.WriteHeader(status)
+	for _, v := range []string{strconv.Itoa(status), "error"} {
+		if DisplayTemplate(uni, v) == nil {
+			return
+		}
+	}
 	uni.Put(err)
 }
 
+// JSON clients either use the json modifier, send a JSON body, or accept only JSON.
+func WantsJSON(uni *context.Uni) bool {
+	if _, isjson := uni.Modifiers["json"]; isjson {
+		return true
+	}
+	for _, v := range []string{uni.Req.Header.Get("Content-Type"), uni.Req.Header.Get("Accept")} {
+		if strings.HasPrefix(v, "application/json") {
+			return true
+		}
+	}
+	return false
+}
+
 func BeforeDisplay(uni *context.Uni) {
 	defer func(){
 		r := recover()
@@ -284,6 +320,7 @@ func D(uni *context.Uni) {
 		err := DisplayFile(uni, point)
 		if err != nil {
 			uni.Dat["missing_file"] = point
+			uni.W.WriteHeader(http.StatusNotFound)
 			err_404 := DisplayFile(uni, "404")
 			if err_404 != nil {
 				uni.Put("Cant find file: ", point)
@@ -0,0 +1,127 @@
+package display_test
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/context"
+	"github.com/opesun/chill/frame/display"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/lang"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type MockEvent struct {}
+
+func (m MockEvent) Fire(s string, params ...interface{}) {
+}
+
+func (m MockEvent) Iterate(s string, ret_rec interface{}, params ...interface{}) {
+}
+
+func write(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The options of numeric enums are numbers, while the value fed back from a form is a string.
+// The bounds of the numbers are rendered even if they are 0.
+func TestSelectedOption(t *testing.T) {
+	root := t.TempDir()
+	for _, v := range []string{"New", "Edit"} {
+		tpl, err := ioutil.ReadFile(filepath.Join("..", "..", "modules", "skeleton", "tpl", v + ".tpl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The header and the footer are not needed.
+		content := strings.NewReplacer("{{require header.t}}", "", "{{require footer.t}}", "").Replace(string(tpl))
+		write(t, filepath.Join(root, "modules", "skeleton", "tpl", v + ".tpl"), content)
+	}
+	field, err := ioutil.ReadFile(filepath.Join("..", "..", "modules", "skeleton", "tpl", "field.t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, filepath.Join(root, "modules", "skeleton", "tpl", "field.t"), string(field))
+	for _, v := range []string{"New", "Edit"} {
+		w := httptest.NewRecorder()
+		uni := &context.Uni{
+			Root:		root,
+			Opt:		map[string]interface{}{},
+			Req:		httptest.NewRequest("GET", "/posts/" + strings.ToLower(v), nil),
+			W:			w,
+			Ev:			&MockEvent{},
+			Route:		&lang.Route{Words: []string{"posts", strings.ToLower(v)}, Queries: []map[string]interface{}{{}, {}}},
+			Sentence:	&lang.Sentence{Noun: "posts", Verb: v},
+			Dat:		map[string]interface{}{
+				"main_noun":	"posts",
+				"main":			[]interface{}{
+					map[string]interface{}{"key": "rating", "input": "select", "options": []interface{}{1.0, 2.0, 3.0}, "value": "2", "error": "Too low."},
+					map[string]interface{}{"key": "stars", "input": "select", "options": []interface{}{1.0, 2.0, 3.0}, "value": 3},
+					map[string]interface{}{"key": "age", "input": "number", "min": 0.0, "max": 150.0, "value": 20},
+					map[string]interface{}{"key": "price", "input": "number", "value": 5},
+				},
+			},
+		}
+		err := display.DisplayTemplate(uni, "skeleton/" + v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := w.Body.String()
+		if strings.Count(body, `selected="selected"`) != 2 || !strings.Contains(body, `<option selected="selected">2</option>`) ||
+			!strings.Contains(body, `<option selected="selected">3</option>`) || !strings.Contains(body, "Too low.") ||
+			!strings.Contains(body, `min="0" max="150"`) || strings.Count(body, "min=") != 1 {
+			t.Fatal(v, body)
+		}
+	}
+}
+
+// The details of the internal errors are not shown to the client, only the request id.
+func TestDErrInternal(t *testing.T) {
+	root := t.TempDir()
+	tpl, err := ioutil.ReadFile(filepath.Join("..", "..", "templates", "public", "default", "error.tpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, filepath.Join(root, "templates", "public", "default", "error.tpl"), string(tpl))
+	for _, v := range []string{"text/html", "application/json"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/posts", nil)
+		req.Header.Set("Accept", v)
+		req.Header.Set(errs.RequestIdHeader, "abc123")
+		uni := &context.Uni{
+			Root:	root,
+			Opt:	map[string]interface{}{},
+			Req:	req,
+			W:		w,
+			Ev:		&MockEvent{},
+			Dat:	map[string]interface{}{},
+		}
+		uni.Put = func(a ...interface{}) {
+			fmt.Fprint(w, a...)
+		}
+		display.DErr(uni, fmt.Errorf("Dial tcp 10.0.0.5:27017: connection refused."))
+		body := w.Body.String()
+		if w.Code != http.StatusInternalServerError || strings.Contains(body, "10.0.0.5") || !strings.Contains(body, "abc123") {
+			t.Fatal(v, w.Code, body)
+		}
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/posts", nil)
+	req.Header.Set("Accept", "application/json")
+	uni := &context.Uni{Req: req, W: w, Dat: map[string]interface{}{}}
+	uni.Put = func(a ...interface{}) {
+		fmt.Fprint(w, a...)
+	}
+	display.DErr(uni, errs.New(errs.NotFound, "No such post."))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "No such post.") {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
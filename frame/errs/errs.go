@@ -0,0 +1,185 @@
+// Package errs contains the error model of the request pipeline.
+// Every error has a Kind, which decides the HTTP status code the error is answered with.
+// Errors without a kind (eg. the ones coming from the standard library) are treated as Internal.
+package errs
+
+import(
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type Kind int
+
+const(
+	Internal Kind = iota
+	NotFound
+	Forbidden
+	Validation
+	BadInput
+	MethodNotAllowed
+	Conflict
+)
+
+var names = map[Kind]string{
+	Internal:			"internal",
+	NotFound:			"not_found",
+	Forbidden:			"forbidden",
+	Validation:			"validation",
+	BadInput:			"bad_input",
+	MethodNotAllowed:	"method_not_allowed",
+	Conflict:			"conflict",
+}
+
+var statuses = map[Kind]int{
+	Internal:			http.StatusInternalServerError,
+	NotFound:			http.StatusNotFound,
+	Forbidden:			http.StatusForbidden,
+	Validation:			http.StatusUnprocessableEntity,
+	BadInput:			http.StatusBadRequest,
+	MethodNotAllowed:	http.StatusMethodNotAllowed,
+	Conflict:			http.StatusConflict,
+}
+
+func (k Kind) String() string {
+	return names[k]
+}
+
+func (k Kind) Status() int {
+	return statuses[k]
+}
+
+// Errors carrying a kind. Packages can implement this on their own error types too, see options.Errors for example.
+type Kinded interface {
+	error
+	Kind() Kind
+}
+
+// Errors which can be traced back to a field of the input implement this too.
+type Fielded interface {
+	error
+	Fields() map[string]string
+}
+
+type Error struct {
+	kind	Kind
+	msg		string
+	err		error			// The original error, if this one wraps an other.
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func (e *Error) Kind() Kind {
+	return e.kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+func New(k Kind, format string, args ...interface{}) error {
+	return &Error{k, fmt.Sprintf(format, args...), nil}
+}
+
+// Gives a kind to err, keeping its message. Returns nil if err is nil.
+func Wrap(k Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{k, err.Error(), err}
+}
+
+// Validation errors of the input data, keyed by field name.
+type Fields map[string]string
+
+func (f Fields) Error() string {
+	keys := []string{}
+	for i := range f {
+		keys = append(keys, i)
+	}
+	sort.Strings(keys)
+	strs := []string{}
+	for _, v := range keys {
+		strs = append(strs, v + ": " + f[v])
+	}
+	return "Invalid input. " + strings.Join(strs, " ")
+}
+
+func (f Fields) Kind() Kind {
+	return Validation
+}
+
+func (f Fields) Fields() map[string]string {
+	return f
+}
+
+// Unique constraint violations are field level errors too, but with the Conflict kind.
+type taken struct {
+	fields	Fields
+}
+
+func (t taken) Error() string {
+	return t.fields.Error()
+}
+
+func (t taken) Kind() Kind {
+	return Conflict
+}
+
+func (t taken) Fields() map[string]string {
+	return t.fields
+}
+
+// The error of a unique constraint violation on the given fields.
+func AlreadyTaken(fields ...string) error {
+	f := Fields{}
+	for _, v := range fields {
+		f[v] = "Already taken."
+	}
+	return taken{f}
+}
+
+// The kind of the outermost error having one in the chain of err.
+func KindOf(err error) Kind {
+	var k Kinded
+	if errors.As(err, &k) {
+		return k.Kind()
+	}
+	return Internal
+}
+
+func Is(err error, k Kind) bool {
+	return err != nil && KindOf(err) == k
+}
+
+func Status(err error) int {
+	return KindOf(err).Status()
+}
+
+// Field level details of err, nil if there are none.
+func FieldsOf(err error) map[string]string {
+	var f Fielded
+	if errors.As(err, &f) {
+		return f.Fields()
+	}
+	return nil
+}
+
+// The JSON representation of an error, being sent to the API clients.
+func Envelope(err error) map[string]interface{} {
+	e := map[string]interface{}{
+		"kind":		KindOf(err).String(),
+		"status":	Status(err),
+		"message":	err.Error(),
+	}
+	if f := FieldsOf(err); f != nil {
+		e["fields"] = f
+	}
+	return map[string]interface{}{
+		"error": e,
+	}
+}
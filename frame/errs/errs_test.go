@@ -0,0 +1,119 @@
+package errs_test
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/errs"
+	"net/http"
+	"testing"
+)
+
+func TestKinds(t *testing.T) {
+	err := errs.New(errs.NotFound, "No %v.", "cars")
+	if err.Error() != "No cars." {
+		t.Fatal(err)
+	}
+	if errs.KindOf(err) != errs.NotFound || errs.Status(err) != http.StatusNotFound {
+		t.Fatal(errs.KindOf(err))
+	}
+	plain := fmt.Errorf("Something.")
+	if errs.KindOf(plain) != errs.Internal || errs.Status(plain) != http.StatusInternalServerError {
+		t.Fatal(errs.KindOf(plain))
+	}
+	if errs.Is(nil, errs.Internal) {
+		t.Fatal()
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if errs.Wrap(errs.BadInput, nil) != nil {
+		t.Fatal()
+	}
+	plain := fmt.Errorf("Bad thing.")
+	err := errs.Wrap(errs.BadInput, plain)
+	if err.Error() != plain.Error() || !errs.Is(err, errs.BadInput) {
+		t.Fatal(err)
+	}
+	// The outermost kind wins.
+	outer := errs.Wrap(errs.Conflict, err)
+	if !errs.Is(outer, errs.Conflict) {
+		t.Fatal(errs.KindOf(outer))
+	}
+	// Kinds survive standard wrapping too.
+	std := fmt.Errorf("Context: %w", err)
+	if !errs.Is(std, errs.BadInput) {
+		t.Fatal(errs.KindOf(std))
+	}
+}
+
+type fielded struct{}
+
+func (f fielded) Error() string {
+	return "Invalid."
+}
+
+func (f fielded) Kind() errs.Kind {
+	return errs.Validation
+}
+
+func (f fielded) Fields() map[string]string {
+	return map[string]string{"name": "Required."}
+}
+
+func TestEnvelope(t *testing.T) {
+	env := errs.Envelope(fielded{})["error"].(map[string]interface{})
+	if env["kind"] != "validation" || env["status"] != 422 || env["message"] != "Invalid." {
+		t.Fatal(env)
+	}
+	if env["fields"].(map[string]string)["name"] != "Required." {
+		t.Fatal(env)
+	}
+	env = errs.Envelope(errs.New(errs.Forbidden, "No."))["error"].(map[string]interface{})
+	if _, has := env["fields"]; has {
+		t.Fatal(env)
+	}
+	if env["status"] != http.StatusForbidden {
+		t.Fatal(env)
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := errs.NewRing(3)
+	if len(r.Reports()) != 0 {
+		t.Fatal(r.Reports())
+	}
+	for _, v := range []string{"a", "b"} {
+		r.Add(errs.Report{Message: v})
+	}
+	reps := r.Reports()
+	if len(reps) != 2 || reps[0].Message != "b" || reps[1].Message != "a" {
+		t.Fatal(reps)
+	}
+	for _, v := range []string{"c", "d", "e"} {
+		r.Add(errs.Report{Message: v})
+	}
+	reps = r.Reports()
+	if len(reps) != 3 || reps[0].Message != "e" || reps[2].Message != "c" {
+		t.Fatal(reps)
+	}
+}
+
+func TestFields(t *testing.T) {
+	var err error = errs.Fields{"slug": "Must be unique.", "age": "Not a number."}
+	if err.Error() != "Invalid input. age: Not a number. slug: Must be unique." {
+		t.Fatal(err)
+	}
+	if !errs.Is(err, errs.Validation) || errs.FieldsOf(err)["age"] != "Not a number." {
+		t.Fatal(errs.FieldsOf(err))
+	}
+}
+
+func TestAlreadyTaken(t *testing.T) {
+	err := errs.AlreadyTaken("make", "model")
+	if !errs.Is(err, errs.Conflict) || errs.Status(err) != http.StatusConflict {
+		t.Fatal(errs.KindOf(err))
+	}
+	f := errs.FieldsOf(err)
+	if len(f) != 2 || f["model"] != "Already taken." {
+		t.Fatal(f)
+	}
+}
@@ -0,0 +1,97 @@
+package errs
+
+import(
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The request id is sent back to the client in this header, so a user reporting a failure can be matched to the logs.
+const RequestIdHeader = "X-Request-Id"
+
+// Generates a random id for a request.
+func NewRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// The id of a request, as set by the recovery layer, see top.Recover.
+func RequestId(req *http.Request) string {
+	return req.Header.Get(RequestIdHeader)
+}
+
+// A failed request.
+type Report struct {
+	RequestId	string		`json:"request_id"`
+	Time		int64		`json:"time"`		// Unix nanoseconds.
+	Method		string		`json:"method"`
+	Host		string		`json:"host"`
+	Path		string		`json:"path"`
+	Kind		string		`json:"kind"`
+	Status		int			`json:"status"`
+	Message		string		`json:"message"`
+	Stack		string		`json:"stack,omitempty"`	// Only panics have one.
+}
+
+func NewReport(req *http.Request, err error, stack []byte) Report {
+	return Report{
+		RequestId:	RequestId(req),
+		Time:		time.Now().UnixNano(),
+		Method:		req.Method,
+		Host:		req.Host,
+		Path:		req.URL.String(),
+		Kind:		KindOf(err).String(),
+		Status:		Status(err),
+		Message:	err.Error(),
+		Stack:		string(stack),
+	}
+}
+
+// Ring keeps the last n reports. It is safe for concurrent use.
+type Ring struct {
+	mut		sync.Mutex
+	reports	[]Report
+	next	int
+	full	bool
+}
+
+func NewRing(n int) *Ring {
+	return &Ring{
+		reports: make([]Report, n),
+	}
+}
+
+// Adds a report, overwriting the oldest one if the ring is full.
+func (r *Ring) Add(rep Report) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if len(r.reports) == 0 {
+		return
+	}
+	r.reports[r.next] = rep
+	r.next = (r.next + 1) % len(r.reports)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Returns the reports, newest first.
+func (r *Ring) Reports() []Report {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	l := r.next
+	if r.full {
+		l = len(r.reports)
+	}
+	ret := make([]Report, 0, l)
+	for i := 1; i <= l; i++ {
+		ret = append(ret, r.reports[(r.next - i + len(r.reports)) % len(r.reports)])
+	}
+	return ret
+}
+
+// The errors of the process, shown to the admins under the _errors noun.
+var Recent = NewRing(100)
@@ -14,6 +14,7 @@ type Ev struct {
 	passOn   	interface{}						// We pass this on to the Init method of the module instances. In reality, this is a *context.Uni.
 	cache		map[string]iface.Instance		// Module instance cache.
 	newModule	func(string) iface.Module
+	listeners	map[string][]func(...interface{})		// Go functions subscribed from the frame itself, see Listen.
 }
 
 func New(pass_on interface{}, hooks map[string]interface{}, newModule func(string)iface.Module) *Ev {
@@ -25,6 +26,7 @@ func New(pass_on interface{}, hooks map[string]interface{}, newModule func(strin
 		pass_on,
 		map[string]iface.Instance{},
 		newModule,
+		map[string][]func(...interface{}){},
 	}
 }
 
@@ -93,6 +95,12 @@ func (e *Ev) NewModuleProducer() func(string) iface.Module {
 	}
 }
 
+// Listen subscribes a Go function to eventname. Unlike the hooks, which come from the option document, listeners are
+// registered by the frame itself, and they are called before the hooks with the same params.
+func (e *Ev) Listen(eventname string, f func(...interface{})) {
+	e.listeners[eventname] = append(e.listeners[eventname], f)
+}
+
 // Fire calls hooks subscribed to eventname, but does not case about their return values.
 func (e *Ev) Fire(eventname string, params ...interface{}) {
 	e.iterate(eventname, nil, params...)
@@ -137,6 +145,9 @@ func (e *Ev) instance(modname string) iface.Instance {
 }
 
 func (e *Ev) iterate(eventname string, stopfunc interface{}, params ...interface{}) {
+	for _, v := range e.listeners[eventname] {
+		v(params...)
+	}
 	subscribed := all(e, eventname)
 	var stopfunc_numin int
 	if stopfunc != nil {
@@ -147,7 +158,7 @@ func (e *Ev) iterate(eventname string, stopfunc interface{}, params ...interface
 		}
 		stopfunc_numin = s.NumIn()
 	}
-	nameized := hooknameize(eventname)
+	nameized := Hooknameize(eventname)
 	for _, hinf := range subscribed {
 		if hinf.methodName == "" {
 			hinf.methodName = nameized
@@ -188,7 +199,7 @@ func (e *Ev) iterate(eventname string, stopfunc interface{}, params ...interface
 
 // Creates a hookname from access path.
 // "content.insert" => "ContentInsert"
-func hooknameize(s string) string {
+func Hooknameize(s string) string {
 	s = strings.Replace(s, ".", " ", -1)
 	s = strings.Title(s)
 	return strings.Replace(s, " ", "", -1)
@@ -106,4 +106,16 @@ func TestStatePreserving(t *testing.T) {
 			t.Fatal(called)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestListen(t *testing.T) {
+	ev := event.New(nil, nil, newModule)
+	got := []interface{}{}
+	ev.Listen("optionsUpdated", func(params ...interface{}) {
+		got = append(got, params...)
+	})
+	ev.Fire("optionsInserted", 1)
+	ev.Fire("optionsUpdated", 2)
+	if len(got) != 1 || got[0] != 2 {
+		t.Fatal(got)
+	}
+}
@@ -0,0 +1,264 @@
+// Package fieldtypes contains the built-in field types of the input schemes (nouns.X.verbs.Y.input).
+// They are added to every extractor on the SanitizerMangler event before the hooks run, so a module can still override them.
+//
+// Example scheme:
+//	{
+//		"email":		{"type": "email", "required": true},
+//		"homepage":		{"type": "url"},
+//		"born":			{"type": "date"},
+//		"slug":			{"type": "slug"},
+//		"color":		{"type": "enum", "values": ["red", "green"]},
+//		"age":			{"type": "integer", "min": 0, "max": 150},
+//		"title":		{"type": "text", "min": 3, "max": 80, "pattern": "[A-Za-z ]+"}
+//	}
+// For text, email, url and slug fields min and max limit the length, for numbers the value.
+// Empty values are extracted as nil, unless the field is required.
+// Dates are stored as unix timestamps.
+package fieldtypes
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/numcon"
+	"github.com/opesun/sanitize"
+	"github.com/opesun/slugify"
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const(
+	date_format		= "2006-01-02"
+	datetime_format	= "2006-01-02T15:04"		// The format of the datetime-local input.
+)
+
+var datetime_formats = []string{time.RFC3339, "2006-01-02T15:04:05", datetime_format, "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// HTML input types of the field types.
+var inputs = map[string]string{
+	"email":	"email",
+	"url":		"url",
+	"date":		"date",
+	"datetime":	"datetime-local",
+	"integer":	"number",
+	"number":	"number",
+	"int":		"number",
+	"float":	"number",
+	"enum":		"select",
+	"bool":		"checkbox",
+	"file":		"file",
+}
+
+// The HTML input type a field of type typ should be rendered as.
+func Input(typ string) string {
+	if in, has := inputs[typ]; has {
+		return in
+	}
+	return "text"
+}
+
+// Converts a stored value back to the format the input of type typ expects, eg. timestamps to dates.
+func FormValue(typ string, val interface{}) interface{} {
+	var format string
+	switch typ {
+	case "date":
+		format = date_format
+	case "datetime":
+		format = datetime_format
+	default:
+		return val
+	}
+	ts, err := numcon.Float64(val)
+	if err != nil {
+		return val
+	}
+	return time.Unix(int64(ts), 0).UTC().Format(format)
+}
+
+func required(s sanitize.Scheme) bool {
+	req, _ := s.Specific["required"].(bool)
+	return req
+}
+
+// Returns the trimmed string form of dat, false if it is empty.
+func str(dat interface{}, s sanitize.Scheme) (string, bool, error) {
+	var ret string
+	switch v := dat.(type) {
+	case nil:
+	case string:
+		ret = strings.TrimSpace(v)
+	case float64, int, int64:
+		ret = fmt.Sprint(v)
+	default:
+		return "", false, fmt.Errorf("Must be a string.")
+	}
+	if ret == "" {
+		if required(s) {
+			return "", false, fmt.Errorf("Required.")
+		}
+		return "", false, nil
+	}
+	return ret, true, nil
+}
+
+func limit(s sanitize.Scheme, name string) (float64, bool) {
+	v, has := s.Specific[name]
+	if !has {
+		return 0, false
+	}
+	f, err := numcon.Float64(v)
+	return f, err == nil
+}
+
+func checkRange(val float64, s sanitize.Scheme) error {
+	if min, ok := limit(s, "min"); ok && val < min {
+		return fmt.Errorf("Must be at least %v.", min)
+	}
+	if max, ok := limit(s, "max"); ok && val > max {
+		return fmt.Errorf("Must be at most %v.", max)
+	}
+	return nil
+}
+
+func checkString(val string, s sanitize.Scheme) error {
+	l := float64(utf8.RuneCountInString(val))
+	if min, ok := limit(s, "min"); ok && l < min {
+		return fmt.Errorf("Must be at least %v characters long.", min)
+	}
+	if max, ok := limit(s, "max"); ok && l > max {
+		return fmt.Errorf("Must be at most %v characters long.", max)
+	}
+	if pat, has := s.Specific["pattern"].(string); has {
+		reg, err := regexp.Compile("^(?:" + pat + ")$")		// The whole value must match, just like with the pattern attribute of HTML inputs.
+		if err != nil {
+			return errs.New(errs.Internal, "Pattern of field %v does not compile: %v", s.Key, err)
+		}
+		if !reg.MatchString(val) {
+			return fmt.Errorf("Has an invalid format.")
+		}
+	}
+	return nil
+}
+
+// Creates a field type from a function working on nonempty strings.
+func stringType(f func(string, sanitize.Scheme) (interface{}, error)) func(interface{}, sanitize.Scheme) (interface{}, error) {
+	return func(dat interface{}, s sanitize.Scheme) (interface{}, error) {
+		val, ok, err := str(dat, s)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return f(val, s)
+	}
+}
+
+func text(val string, s sanitize.Scheme) (interface{}, error) {
+	return val, checkString(val, s)
+}
+
+func email(val string, s sanitize.Scheme) (interface{}, error) {
+	addr, err := mail.ParseAddress(val)
+	if err != nil || addr.Address != val {
+		return nil, fmt.Errorf("Not a valid email address.")
+	}
+	return val, checkString(val, s)
+}
+
+func urlType(val string, s sanitize.Scheme) (interface{}, error) {
+	u, err := url.Parse(val)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Not a valid URL.")
+	}
+	return val, checkString(val, s)
+}
+
+func slug(val string, s sanitize.Scheme) (interface{}, error) {
+	sl := slugify.S(val)
+	if sl == "" {
+		return nil, fmt.Errorf("Must contain letters or digits.")
+	}
+	return sl, checkString(sl, s)
+}
+
+func enum(val string, s sanitize.Scheme) (interface{}, error) {
+	values, _ := s.Specific["values"].([]interface{})
+	strs := []string{}
+	for _, v := range values {
+		if fmt.Sprint(v) == val {
+			return v, nil
+		}
+		strs = append(strs, fmt.Sprint(v))
+	}
+	return nil, fmt.Errorf("Must be one of %v.", strings.Join(strs, ", "))
+}
+
+func timeType(formats ...string) func(interface{}, sanitize.Scheme) (interface{}, error) {
+	return func(dat interface{}, s sanitize.Scheme) (interface{}, error) {
+		if f, ok := dat.(float64); ok {		// Already a timestamp, eg. coming from a JSON body.
+			return int64(f), nil
+		}
+		val, ok, err := str(dat, s)
+		if err != nil || !ok {
+			return nil, err
+		}
+		for _, v := range formats {
+			t, err := time.Parse(v, val)
+			if err == nil {
+				return t.Unix(), nil
+			}
+		}
+		return nil, fmt.Errorf("Not a valid date.")
+	}
+}
+
+func number(integer bool) func(interface{}, sanitize.Scheme) (interface{}, error) {
+	return func(dat interface{}, s sanitize.Scheme) (interface{}, error) {
+		var f float64
+		var err error
+		if _, is_str := dat.(string); is_str || dat == nil {
+			val, ok, serr := str(dat, s)
+			if serr != nil || !ok {
+				return nil, serr
+			}
+			f, err = strconv.ParseFloat(val, 64)
+		} else {
+			f, err = numcon.Float64(dat)
+		}
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("Not a number.")
+		}
+		if integer && f != math.Trunc(f) {
+			return nil, fmt.Errorf("Must be a whole number.")
+		}
+		if err := checkRange(f, s); err != nil {
+			return nil, err
+		}
+		if integer {
+			return int64(f), nil
+		}
+		return f, nil
+	}
+}
+
+func Funcs() sanitize.FuncMap {
+	return sanitize.FuncMap{
+		"text":		stringType(text),
+		"email":	stringType(email),
+		"url":		stringType(urlType),
+		"slug":		stringType(slug),
+		"enum":		stringType(enum),
+		"date":		timeType(date_format),
+		"datetime":	timeType(datetime_formats...),
+		"integer":	number(true),
+		"number":	number(false),
+	}
+}
+
+// Adds the built-in field types to ex.
+func Add(ex *sanitize.Extractor) {
+	ex.AddFuncs(Funcs())
+}
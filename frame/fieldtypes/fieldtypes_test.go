@@ -0,0 +1,97 @@
+package fieldtypes_test
+
+import(
+	"github.com/opesun/chill/frame/fieldtypes"
+	"github.com/opesun/sanitize"
+	"testing"
+)
+
+func extract(t *testing.T, scheme, data map[string]interface{}) (map[string]interface{}, error) {
+	ex, err := sanitize.New(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fieldtypes.Add(ex)
+	return ex.Extract(data)
+}
+
+func TestValid(t *testing.T) {
+	scheme := map[string]interface{}{
+		"email":	map[string]interface{}{"type": "email"},
+		"home":		map[string]interface{}{"type": "url"},
+		"born":		map[string]interface{}{"type": "date"},
+		"at":		map[string]interface{}{"type": "datetime"},
+		"color":	map[string]interface{}{"type": "enum", "values": []interface{}{"red", "green"}},
+		"age":		map[string]interface{}{"type": "integer", "min": 0, "max": 150},
+		"price":	map[string]interface{}{"type": "number", "max": 10},
+		"title":	map[string]interface{}{"type": "text", "min": 2, "max": 5, "pattern": "[a-z]+"},
+		"note":		map[string]interface{}{"type": "text"},
+	}
+	data := map[string]interface{}{
+		"email":	" john@example.com ",
+		"home":		"http://example.com/a",
+		"born":		"1970-01-02",
+		"at":		"1970-01-01T00:01",
+		"color":	"green",
+		"age":		"42",
+		"price":	9.5,
+		"title":	"abc",
+		"note":		"",
+	}
+	res, err := extract(t, scheme, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"email":	"john@example.com",
+		"home":		"http://example.com/a",
+		"born":		int64(86400),
+		"at":		int64(60),
+		"color":	"green",
+		"age":		int64(42),
+		"price":	9.5,
+		"title":	"abc",
+		"note":		nil,
+	}
+	for i, v := range expected {
+		if res[i] != v {
+			t.Fatal(i, res[i])
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	cases := []struct{
+		scheme	map[string]interface{}
+		val		interface{}
+	}{
+		{map[string]interface{}{"type": "email"}, "john"},
+		{map[string]interface{}{"type": "url"}, "javascript:alert(1)"},
+		{map[string]interface{}{"type": "date"}, "1970.01.02"},
+		{map[string]interface{}{"type": "enum", "values": []interface{}{"red"}}, "blue"},
+		{map[string]interface{}{"type": "integer"}, "4.5"},
+		{map[string]interface{}{"type": "integer", "min": 5}, 4},
+		{map[string]interface{}{"type": "number"}, "x"},
+		{map[string]interface{}{"type": "text", "max": 2}, "abc"},
+		{map[string]interface{}{"type": "text", "pattern": "[a-z]+"}, "abc1"},
+		{map[string]interface{}{"type": "text", "required": true}, " "},
+	}
+	for _, v := range cases {
+		_, err := extract(t, map[string]interface{}{"f": v.scheme}, map[string]interface{}{"f": v.val})
+		if err == nil {
+			t.Fatal(v)
+		}
+	}
+}
+
+func TestInput(t *testing.T) {
+	if fieldtypes.Input("email") != "email" || fieldtypes.Input("datetime") != "datetime-local" || fieldtypes.Input("whatever") != "text" {
+		t.Fatal()
+	}
+	if fieldtypes.FormValue("date", int64(86400)) != "1970-01-02" {
+		t.Fatal(fieldtypes.FormValue("date", int64(86400)))
+	}
+	if fieldtypes.FormValue("date", nil) != nil {
+		t.Fatal()
+	}
+}
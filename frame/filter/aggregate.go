@@ -0,0 +1,106 @@
+package filter
+
+import(
+	"github.com/opesun/chill/frame/errs"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/misc/convert"
+	"github.com/opesun/chill/frame/set/query"
+	"strings"
+)
+
+// Reads a list given either as a comma separated string, or multiple times.
+func listOf(v interface{}) []string {
+	var strs []string
+	switch val := v.(type) {
+	case string:
+		strs = []string{val}
+	case []string:
+		strs = val
+	case []interface{}:
+		strs = convert.ToStringSlice(val...)
+	}
+	ret := []string{}
+	for _, s := range strs {
+		for _, x := range strings.Split(s, ",") {
+			if x = strings.TrimSpace(x); x != "" {
+				ret = append(ret, x)
+			}
+		}
+	}
+	return ret
+}
+
+// ParseAggregation builds an aggregation from its URL (or scheme) form, eg.
+//	by=author&ops=count,sum:total					-> number of posts and the sum of their totals per author
+//	by=created:day&ops=sum:total					-> totals per day
+//	ops=distinct:tags								-> the distinct tags
+// The group fields can be followed by a date bucket (hour, day, month or year), the operations by the field they work on.
+// Count is used if no operation is given.
+func ParseAggregation(spec map[string]interface{}) (iface.Aggregation, error) {
+	agg := iface.Aggregation{}
+	for _, v := range listOf(spec["by"]) {
+		parts := strings.SplitN(v, ":", 2)
+		by := iface.GroupBy{Field: parts[0]}
+		if len(parts) == 2 {
+			by.Bucket = parts[1]
+		}
+		agg.By = append(agg.By, by)
+	}
+	for _, v := range listOf(spec["ops"]) {
+		parts := strings.SplitN(v, ":", 2)
+		op := iface.AggOp{Op: parts[0]}
+		if len(parts) == 2 {
+			op.Field = parts[1]
+		}
+		agg.Ops = append(agg.Ops, op)
+	}
+	if len(agg.Ops) == 0 {
+		agg.Ops = []iface.AggOp{{Op: "count"}}
+	}
+	if _, err := query.NewAggregator(agg); err != nil {
+		return agg, errs.New(errs.BadInput, "%v", err)
+	}
+	return agg, nil
+}
+
+// Aggregate runs agg on the documents matching the filter. The sort, skip and limit modifiers apply to the resulting rows,
+// eg. sort=-count&limit=10 gives the ten largest groups. Unlike at Find, all the rows are returned if no limit is given.
+// Forbidden and hidden fields can't be aggregated.
+func (f *Filter) Aggregate(agg iface.Aggregation) ([]map[string]interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	forbidden := map[string]bool{}
+	for _, v := range append(append([]string{}, f.forbidden...), query.Hidden...) {
+		forbidden[v] = true
+	}
+	fields := []string{}
+	for _, v := range agg.By {
+		fields = append(fields, v.Field)
+	}
+	for _, v := range agg.Ops {
+		fields = append(fields, v.Field)
+	}
+	for _, v := range fields {
+		if forbidden[strings.SplitN(v, ".", 2)[0]] {
+			return nil, errs.New(errs.BadInput, "Field %v can't be aggregated.", v)
+		}
+	}
+	q := mergeQuery(f.query, f.parents)
+	rows, err := f.set.Aggregate(q, agg)
+	if err != nil {
+		return nil, err
+	}
+	query.Sort(rows, f.mods.sort)
+	if f.mods.skip > 0 {
+		if f.mods.skip >= len(rows) {
+			rows = rows[:0]
+		} else {
+			rows = rows[f.mods.skip:]
+		}
+	}
+	if f.mods.limited && f.mods.limit > 0 && len(rows) > f.mods.limit {
+		rows = rows[:f.mods.limit]
+	}
+	return rows, nil
+}
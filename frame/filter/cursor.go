@@ -0,0 +1,194 @@
+package filter
+
+import(
+	"encoding/base64"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/set/query"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+// Keyset pagination: instead of skipping documents, the page starts right after (or before) the document the cursor points to.
+// A cursor holds the values of the sort keys of a document, including the _id, which is always the last sort key, so the order
+// is total. The cursors are opaque for the clients, they are passed back in the after and before modifiers, eg.
+//	/posts?sort=-created&limit=10&after=<cursor>
+// Cursors only make sense with the sort order they were created with.
+
+type cursor struct {
+	V	[]interface{}	`bson:"v"`
+}
+
+// The sort order of the paginated queries, the _id breaks the ties.
+func (f *Filter) sortKeys() []string {
+	keys := append([]string{}, f.mods.sort...)
+	for _, v := range keys {
+		if strings.TrimPrefix(v, "-") == "_id" {
+			return keys
+		}
+	}
+	return append(keys, "_id")
+}
+
+func encodeCursor(doc map[string]interface{}, keys []string) string {
+	c := cursor{}
+	for _, v := range keys {
+		val, _ := query.Get(doc, strings.TrimPrefix(v, "-"))
+		c.V = append(c.V, val)
+	}
+	b, err := bson.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string, keys []string) ([]interface{}, error) {
+	bad := errs.New(errs.BadInput, "Invalid cursor.")
+	b, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, bad
+	}
+	c := cursor{}
+	if err := bson.Unmarshal(b, &c); err != nil {
+		return nil, bad
+	}
+	if len(c.V) != len(keys) {
+		return nil, errs.New(errs.BadInput, "Cursor does not match the sort order.")
+	}
+	return c.V, nil
+}
+
+// Reverses the direction of the sort keys.
+func reverseKeys(keys []string) []string {
+	ret := []string{}
+	for _, v := range keys {
+		if strings.HasPrefix(v, "-") {
+			ret = append(ret, v[1:])
+		} else {
+			ret = append(ret, "-" + v)
+		}
+	}
+	return ret
+}
+
+// The condition of the documents coming after vals in the order of keys, eg. for keys ["-created", "_id"]:
+//	{"$or": [{"created": {"$lt": c}}, {"created": c, "_id": {"$gt": id}}]}
+func afterQuery(keys []string, vals []interface{}) map[string]interface{} {
+	or := []interface{}{}
+	for i, k := range keys {
+		clause := map[string]interface{}{}
+		for j := 0; j < i; j++ {
+			clause[strings.TrimPrefix(keys[j], "-")] = vals[j]
+		}
+		op := "$gt"
+		if strings.HasPrefix(k, "-") {
+			op = "$lt"
+		}
+		clause[strings.TrimPrefix(k, "-")] = map[string]interface{}{op: vals[i]}
+		or = append(or, clause)
+	}
+	return map[string]interface{}{"$or": or}
+}
+
+// Adds cond to q without overwriting its members.
+func andQuery(q, cond map[string]interface{}) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for i, v := range q {
+		ret[i] = v
+	}
+	and, _ := ret["$and"].([]interface{})
+	ret["$and"] = append(append([]interface{}{}, and...), cond)
+	return ret
+}
+
+func toDocs(res []interface{}) []map[string]interface{} {
+	ret := []map[string]interface{}{}
+	for _, v := range res {
+		if doc, ok := v.(map[string]interface{}); ok {
+			ret = append(ret, doc)
+		}
+	}
+	return ret
+}
+
+// Runs the query of Find one page at a time, and sets the cursors of the neighbouring pages.
+// When paging by a cursor, one more document is fetched than the limit, to know if there is a next page.
+func (f *Filter) findPage(q map[string]interface{}) ([]interface{}, error) {
+	f.prev, f.next = "", ""
+	keys := f.sortKeys()
+	after, before := f.mods.after, f.mods.before
+	if after != "" && before != "" {
+		return nil, errs.New(errs.BadInput, "Only one of after and before can be given.")
+	}
+	backward := before != ""
+	limit := f.mods.limit
+	var more bool
+	var res []interface{}
+	var err error
+	if after == "" && before == "" {
+		// The first page, or one reached by skipping. Sorted by the same keys as the following pages, or the ties would come in
+		// an other order there. The next cursor is given if the page is full, even if the next page turns out to be empty.
+		f.set.Skip(f.mods.skip)
+		f.set.Limit(limit)
+		f.set.Sort(keys...)
+		res, err = f.set.Find(q)
+		if err != nil {
+			return nil, err
+		}
+		more = limit != 0 && len(res) == limit
+	} else {
+		vals, err := decodeCursor(after + before, keys)
+		if err != nil {
+			return nil, err
+		}
+		sort := keys
+		if backward {
+			sort = reverseKeys(keys)
+		}
+		f.set.Skip(0)
+		f.set.Sort(sort...)
+		f.set.Limit(0)
+		if limit != 0 {
+			f.set.Limit(limit + 1)
+		}
+		res, err = f.set.Find(andQuery(q, afterQuery(sort, vals)))
+		if err != nil {
+			return nil, err
+		}
+		more = limit != 0 && len(res) > limit
+		if more {
+			res = res[:limit]
+		}
+	}
+	if backward {
+		for i, j := 0, len(res) - 1; i < j; i, j = i + 1, j - 1 {
+			res[i], res[j] = res[j], res[i]
+		}
+	}
+	docs := toDocs(res)
+	if len(docs) == 0 {
+		return res, nil
+	}
+	first, last := docs[0], docs[len(docs) - 1]
+	switch {
+	case backward:
+		f.next = encodeCursor(last, keys)
+		if more {
+			f.prev = encodeCursor(first, keys)
+		}
+	default:
+		if more {
+			f.next = encodeCursor(last, keys)
+		}
+		if after != "" || f.mods.skip != 0 {
+			f.prev = encodeCursor(first, keys)
+		}
+	}
+	return res, nil
+}
+
+// Cursors returns the before cursor of the previous page and the after cursor of the next page of the last Find.
+// A cursor is empty if there is no such page.
+func (f *Filter) Cursors() (string, string) {
+	return f.prev, f.next
+}
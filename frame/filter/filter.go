@@ -7,12 +7,19 @@ import(
 	"github.com/opesun/chill/frame/misc/convert"
 	"github.com/opesun/chill/frame/grabbed"
 	"github.com/opesun/sanitize"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/set/query"
+	"strings"
 )
 
 type Mods struct {
 	skip		int
 	limit		int
+	limited		bool			// The limit was given, it is not the default one.
 	sort		[]string
+	fields		[]string		// The fields asked for, the ones prefixed with "-" are to be left out.
+	after		string			// Cursors, see findPage.
+	before		string
 }
 
 func (m *Mods)  Skip() int {
@@ -27,15 +34,24 @@ func (m *Mods) Sort() []string {
 	return m.sort
 }
 
+func (m *Mods) Fields() []string {
+	return m.fields
+}
+
 // Parents are separated from the query, because they are not used only at querying (FindOne, Find, Update, UpdateAll, Remove, RemoveAll),
 // but at Insert too.
 type Filter struct {
 	set				iface.Set
 	mods			*Mods
-	parentField		map[string]string				// collection_name => fieldname, being used at reduction, shortcoming: 1 collection to 1 fieldname only...
+	rels			Relations						// Used at reduction, see Relate.
 	parents			map[string][]bson.ObjectId		// fieldnames => []bson.ObjectId
 	query			map[string]interface{}
+	prev			string							// Cursors of the pages around the result of the last Find.
+	next			string
+	defaults		[]string						// Fields returned if none is asked for, see Project.
+	forbidden		[]string						// Fields never returned.
 	ev				iface.Event
+	err				error							// Bad input given at construction, every operation returns it.
 }
 
 func (f *Filter) Visualize() {
@@ -46,24 +62,6 @@ func (f *Filter) Visualize() {
 	fmt.Println(">>>")
 }
 
-func (f *Filter) Reduce(a ...iface.Filter) (iface.Filter, error) {
-	l := len(a)
-	if l == 0 {
-		return &Filter{}, fmt.Errorf("Nothing to reduce.")
-	}
-	var prev iface.Filter
-	prev = f
-	for _, v := range a {
-		ids, err := prev.Ids()
-		if err != nil {
-			return &Filter{}, err
-		}
-		v.AddParents("_" + prev.Subject(), ids)
-		prev = v
-	}
-	return prev, nil
-}
-
 // Information coming from url.Values/map
 type data struct {
 	query 		map[string]interface{}
@@ -71,9 +69,47 @@ type data struct {
 	parentField	string
 }
 
+// What the input coming from the client may contain, see NewAllowed.
+type rules struct {
+	ops		Allowed
+	plain	map[string]bool
+}
+
+func (r *rules) declared(key string) bool {
+	_, op := r.ops[key]
+	return key == "id" || op || r.plain[key]
+}
+
+// Reports if v is a plain value, and not a map which could smuggle in operators.
+func plainValue(v interface{}) bool {
+	switch val := v.(type) {
+	case map[string]interface{}, bson.M:
+		return false
+	case []interface{}:
+		for _, x := range val {
+			if !plainValue(x) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Removes the keys starting with "$" and the ones with non plain values from the client input.
+func untrusted(inp map[string]interface{}) {
+	for i, v := range inp {
+		if strings.HasPrefix(i, "$") || !plainValue(v) {
+			delete(inp, i)
+		}
+	}
+}
+
 // Special fields in query:
-// parentf, sort, limit, skip, page
-func processMap(inp map[string]interface{}, ev iface.Event) *data {
+// parentf, sort, limit, skip, page, fields, after, before
+// Input coming from the client is processed according to r: raw operators and undeclared fields are left out, the operator syntax
+// of Allowed is parsed.
+// Queries coming from code pass a nil r, they can use the Mongo operators directly. The keys added by the ProcessMap hooks are trusted too.
+func processMap(inp map[string]interface{}, ev iface.Event, r *rules) (*data, error) {
 	d := &data{}
 	if inp == nil {
 		inp = map[string]interface{}{}
@@ -90,14 +126,20 @@ func processMap(inp map[string]interface{}, ev iface.Event) *data {
 		"skip": int_sch,
 		"limit": int_sch,
 		"page": int_sch,
+		"fields": map[string]interface{}{
+			"slice": true,
+			"type": "string",
+		},
+		"after": 1,
+		"before": 1,
 	}
 	ex, err := sanitize.New(sch)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	dat, err := ex.Extract(inp)
 	if err != nil {
-		panic(err)
+		return nil, errs.Wrap(errs.BadInput, err)
 	}
 	for i := range sch {
 		delete(inp, i)
@@ -107,38 +149,84 @@ func processMap(inp map[string]interface{}, ev iface.Event) *data {
 	//	d.parentField = dat["parentf"].(string)
 	//}
 	if dat["sort"] != nil {
-		mods.sort = convert.ToStringSlice(dat["sort"].([]interface{}))
+		mods.sort = convert.ToStringSlice(dat["sort"].([]interface{})...)
 	}
 	if dat["skip"] != nil {
 		mods.skip = int(dat["skip"].(int64))
 	}
+	mods.after, _ = dat["after"].(string)
+	mods.before, _ = dat["before"].(string)
+	if dat["fields"] != nil {
+		for _, v := range convert.ToStringSlice(dat["fields"].([]interface{})...) {		// Eg. fields=title,slug
+			for _, f := range strings.Split(v, ",") {
+				if f = strings.TrimSpace(f); f != "" {
+					mods.fields = append(mods.fields, f)
+				}
+			}
+		}
+	}
 	if dat["limit"] != nil {
 		mods.limit = int(dat["limit"].(int64))
+		mods.limited = true
 	} else {
 		mods.limit = 20
 	}
 	if dat["page"] != nil {
 		page := int(dat["page"].(int64))
 		mods.skip = (page-1)*mods.limit
+		mods.limited = true
 	}
 	d.mods = mods
+	var ops map[string]interface{}
+	var or []interface{}
+	orig := map[string]bool{}
+	if r != nil {
+		untrusted(inp)
+		ops, or, err = parseOps(inp, r.ops)
+		if err != nil {
+			return nil, err
+		}
+		for i := range inp {
+			orig[i] = true
+		}
+	}
 	ev.Fire("ProcessMap", inp)	// We should let the subscriber now the subject name.
-	d.query = toQuery(inp)
-	return d
+	for i := range inp {
+		if orig[i] && !r.declared(i) {
+			delete(inp, i)
+		}
+	}
+	d.query, err = toQuery(inp)
+	if err != nil {
+		return nil, err
+	}
+	mergeOps(d.query, ops, or)
+	return d, nil
 }
 
-func convAppend(vi []interface{}, i *string, x interface{}) []interface{} {
+func convAppend(vi []interface{}, i *string, x interface{}) ([]interface{}, error) {
 	if *i == "id" {
 		*i = "_id"
-		vi = append(vi, convert.DecodeIdP(x.(string)))
+		switch id := x.(type) {
+		case bson.ObjectId:
+			vi = append(vi, id)
+		case string:
+			dec, err := convert.DecodeId(id)
+			if err != nil {
+				return nil, err
+			}
+			vi = append(vi, dec)
+		default:
+			return nil, errs.New(errs.BadInput, "Id must be a string.")
+		}
 	} else {
 		vi = append(vi, x)
 	}
-	return vi
+	return vi, nil
 }
 
 // map => mongodb query map
-func toQuery(a map[string]interface{}) map[string]interface{} {
+func toQuery(a map[string]interface{}) (map[string]interface{}, error) {
 	r := map[string]interface{}{}
 	for i, v := range a {
 		if i[0] == '$' {
@@ -146,12 +234,22 @@ func toQuery(a map[string]interface{}) map[string]interface{} {
 			continue
 		}
 		var vi []interface{}
+		var err error
 		if slice, ok := v.([]interface{}); ok {
 			for _, x := range slice {
-				vi = convAppend(vi, &i, x)
+				vi, err = convAppend(vi, &i, x)
+				if err != nil {
+					return nil, err
+				}
 			}
 		} else {
-			vi = convAppend(vi, &i, v)
+			vi, err = convAppend(vi, &i, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(vi) == 0 {
+			continue
 		}
 		if len(vi) > 1 {		// Ex: {"$and": [{"fulltext": ^"whateverr"}, {...}]}
 			r[i] = map[string]interface{}{
@@ -161,7 +259,7 @@ func toQuery(a map[string]interface{}) map[string]interface{} {
 			r[i] = vi[0]
 		}
 	}
-	return r
+	return r, nil
 }
 
 func NewSimple(set iface.Set, ev iface.Event) *Filter {
@@ -172,8 +270,33 @@ func NewSimple(set iface.Set, ev iface.Event) *Filter {
 	}
 }
 
+// Bad input does not make New fail, the error is returned by the first operation called on the Filter instead.
+// The input is trusted, it can contain Mongo operators, so it must not come from the client, see NewAllowed.
 func New(set iface.Set, ev iface.Event, all map[string]interface{}) *Filter {
-	d := processMap(all, ev)
+	return newFilter(set, ev, all, nil)
+}
+
+// NewAllowed creates a Filter from client input. Only the "id", the fields in allowed and plain can be queried, the fields in allowed
+// with the operators too. Raw Mongo operators are never accepted, the rest of the input is left out of the query.
+func NewAllowed(set iface.Set, ev iface.Event, all map[string]interface{}, allowed Allowed, plain []string) *Filter {
+	r := &rules{allowed, map[string]bool{}}
+	for _, v := range plain {
+		r.plain[v] = true
+	}
+	return newFilter(set, ev, all, r)
+}
+
+func newFilter(set iface.Set, ev iface.Event, all map[string]interface{}, r *rules) *Filter {
+	d, err := processMap(all, ev, r)
+	if err != nil {
+		return &Filter{
+			set:		set,
+			mods:		&Mods{},
+			parents:	map[string][]bson.ObjectId{},
+			ev:			ev,
+			err:		err,
+		}
+	}
 	f := &Filter{
 		set:			set,
 		mods:			d.mods,
@@ -185,107 +308,344 @@ func New(set iface.Set, ev iface.Event, all map[string]interface{}) *Filter {
 	return f
 }
 
+// Deep copies the maps and slices of a query, so the copy can be changed without affecting the original.
+func copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		ret := map[string]interface{}{}
+		for i, x := range val {
+			ret[i] = copyValue(x)
+		}
+		return ret
+	case bson.M:
+		ret := bson.M{}
+		for i, x := range val {
+			ret[i] = copyValue(x)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(val))
+		for i, x := range val {
+			ret[i] = copyValue(x)
+		}
+		return ret
+	case []bson.ObjectId:
+		return append([]bson.ObjectId{}, val...)
+	case []string:
+		return append([]string{}, val...)
+	}
+	return v
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func (f *Filter) clone() *Filter {
+	mods := &Mods{}
+	if f.mods != nil {
+		*mods = *f.mods
+		mods.sort = copyStrings(f.mods.sort)
+		mods.fields = copyStrings(f.mods.fields)
+	}
+	var query map[string]interface{}
+	if f.query != nil {
+		query = copyValue(f.query).(map[string]interface{})
+	}
+	parents := map[string][]bson.ObjectId{}
+	for i, v := range f.parents {
+		parents[i] = append([]bson.ObjectId{}, v...)
+	}
+	return &Filter{
+		set:			f.set,
+		mods:			mods,
+		rels:			f.rels,
+		parents:		parents,
+		query:			query,
+		prev:			f.prev,
+		next:			f.next,
+		defaults:		copyStrings(f.defaults),
+		forbidden:		copyStrings(f.forbidden),
+		ev:				f.ev,
+		err:			f.err,
+	}
+}
+
+// Clone returns a deep copy of the filter, changing one of them leaves the other intact.
+// The Set is shared, but every operation sets it up before running the query.
 func (f *Filter) Clone() iface.Filter {
-	//panic("Clone is not implemented yet.")
-	//q := copyMap()
-	//p := copySlice()
-	//return &Filter{
-	//	set:			f.set,
-	//	mods:			&*d.mods,
-	//	parentField:	d.ParentField,
-	//	query:			d.query
-	//	parents:		d.parents
-	//}
-	return f
+	return f.clone()
 }
 
 func (f *Filter) Modifiers() iface.Modifiers {
 	return f.mods
 }
 
+// Project sets the fields returned when the fields modifier is not given, and the ones which can't be asked for at all.
+// Forbidden fields are never returned.
+func (f *Filter) Project(defaults, forbidden []string) *Filter {
+	f.defaults = defaults
+	f.forbidden = forbidden
+	return f
+}
+
+// The projection of Find and FindOne, built from the fields modifier and the defaults.
+// The forbidden and hidden fields can't be sorted by either, their order would leak, and the cursors are built from the values
+// of the sort keys.
+func (f *Filter) selector() (map[string]int, error) {
+	sel := map[string]int{}
+	forbidden := map[string]bool{}
+	for _, v := range f.forbidden {
+		forbidden[v] = true
+	}
+	hidden := map[string]bool{}
+	for _, v := range query.Hidden {
+		hidden[v] = true
+	}
+	for _, v := range f.mods.sort {
+		name := strings.SplitN(strings.TrimPrefix(v, "-"), ".", 2)[0]
+		if forbidden[name] || hidden[name] {
+			return nil, errs.New(errs.BadInput, "Field %v can't be sorted by.", name)
+		}
+	}
+	fields := f.mods.fields
+	if len(fields) == 0 {
+		fields = f.defaults
+	}
+	excl, incl := false, false
+	for _, v := range fields {
+		name := strings.TrimPrefix(v, "-")
+		if forbidden[name] && name != v {
+			continue		// Left out anyway.
+		}
+		if forbidden[name] {
+			return nil, errs.New(errs.BadInput, "Field %v can't be asked for.", name)
+		}
+		if name != v {
+			excl = true
+			sel[name] = 0
+		} else {
+			incl = true
+			sel[name] = 1
+		}
+	}
+	if excl && incl {
+		return nil, errs.New(errs.BadInput, "Fields can be either listed or left out, not both.")
+	}
+	if !incl {
+		for i := range forbidden {
+			sel[i] = 0
+		}
+	}
+	return sel, nil
+}
+
+// AddQuery returns a new filter with q added to the query, the receiver is left intact.
+// The members of the original query take precedence over the ones in q.
 func (f *Filter) AddQuery(q map[string]interface{}) iface.Filter {
-	query := processMap(q, f.ev).query
-	for i, v := range f.query {
+	ret := f.clone()
+	d, err := processMap(copyValue(q).(map[string]interface{}), f.ev, nil)
+	if err != nil {
+		ret.err = err
+		return ret
+	}
+	query := d.query
+	for i, v := range ret.query {
 		query[i] = v
 	}
-	f.query = query
-	return f
+	ret.query = query
+	return ret
 }
 
+// Fields present both in the query and the parents are put into an $and, so neither of them can widen the other.
 func mergeQuery(q map[string]interface{}, p map[string][]bson.ObjectId) map[string]interface{} {
 	r := map[string]interface{}{}
 	for i, v := range q {
 		r[i] = v
 	}
+	var and []interface{}
 	for i, v := range p {
-		r[i] = map[string]interface{}{
+		in := map[string]interface{}{
 			"$in": v,
 		}
+		if qv, has := r[i]; has {
+			and = append(and, map[string]interface{}{i: qv}, map[string]interface{}{i: in})
+			delete(r, i)
+		} else {
+			r[i] = in
+		}
+	}
+	if len(and) > 0 {
+		prev, _ := r["$and"].([]interface{})
+		r["$and"] = append(append([]interface{}{}, prev...), and...)
 	}
 	return r
 }
 
+// The _id parent comes from a reverse relation (see Relations), the new document can't be linked that way.
 func mergeInsert(ins map[string]interface{}, p map[string][]bson.ObjectId) map[string]interface{} {
 	r := map[string]interface{}{}
 	for i, v := range ins {
 		r[i] = v
 	}
 	for i, v := range p {
+		if i == "_id" {
+			continue
+		}
 		r[i] = v
 	}
 	return r
 }
 
 func (f *Filter) FindOne() (map[string]interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	sel, err := f.selector()
+	if err != nil {
+		return nil, err
+	}
+	f.set.Select(sel)
 	q := mergeQuery(f.query, f.parents)
 	return f.set.FindOne(q)
 }
 
 func (f *Filter) Find() ([]interface{}, error) {
-	if f.mods.skip != 0 {
-		f.set.Skip(f.mods.skip)
+	if f.err != nil {
+		return nil, f.err
 	}
-	if f.mods.limit != 0 {
-		f.set.Limit(f.mods.limit)
+	sel, err := f.selector()
+	if err != nil {
+		return nil, err
 	}
-	if len(f.mods.sort) > 0 {
-		f.set.Sort(f.mods.sort...)
+	if inclusive(sel) {
+		for _, v := range f.mods.sort {		// The cursors are built from the sort keys, the _id is returned anyway.
+			sel[strings.TrimPrefix(v, "-")] = 1
+		}
 	}
+	f.set.Select(sel)
 	q := mergeQuery(f.query, f.parents)
-	return f.set.Find(q)
+	return f.findPage(q)
 }
 
-func (f *Filter) Iterate(callback func(map[string]interface{}, iface.Grabbed) error) error {
-	f.set.Limit(0)
-	q := mergeQuery(f.query, f.parents)
-	docs, err := f.set.Find(q)
+func inclusive(sel map[string]int) bool {
+	for _, v := range sel {
+		if v != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of documents fetched at once by Iterate, if the options do not say otherwise.
+const DefaultBatch = 100
+
+var ErrCanceled = errs.New(errs.Internal, "Iteration canceled.")
+
+// Reads a batch of documents from the Set. The cursor is closed before the documents are processed, so the callbacks of
+// Iterate can write the collection even if the backend does not allow that during a read.
+func (f *Filter) readBatch(q map[string]interface{}, batch int) ([]map[string]interface{}, error) {
+	f.set.Skip(0)
+	f.set.Limit(batch)
+	f.set.Sort("_id")
+	f.set.Select(nil)
+	c, err := f.set.Iter(q, batch)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, v := range docs {
-		doc := v.(map[string]interface{})
-		grab := grabbed.New(f.set, doc["_id"].(bson.ObjectId))
-		// Should we delete the id?
-		// delete(doc, "_id")
-		err := callback(doc, grab)
+	defer c.Close()
+	docs := []map[string]interface{}{}
+	for {
+		doc, ok := c.Next()
+		if !ok {
+			break
+		}
+		docs = append(docs, doc)
+	}
+	return docs, c.Err()
+}
+
+// Iterate calls callback with every document matching the filter, without loading all of them into memory.
+// The documents are fetched in batches ordered by _id, each batch starting after the last document of the previous one,
+// so documents changed by callback are not visited twice. opts can be nil, see iface.IterOptions.
+// Returns ErrCanceled if opts.Cancel is closed before all documents are processed.
+func (f *Filter) Iterate(opts *iface.IterOptions, callback func(map[string]interface{}, iface.Grabbed) error) error {
+	if f.err != nil {
+		return f.err
+	}
+	if opts == nil {
+		opts = &iface.IterOptions{}
+	}
+	batch := opts.Batch
+	if batch <= 0 {
+		batch = DefaultBatch
+	}
+	q := mergeQuery(f.query, f.parents)
+	total := 0
+	if opts.Progress != nil {
+		c, err := f.set.Count(q)
 		if err != nil {
 			return err
 		}
+		total = c
+	}
+	done := 0
+	var last interface{}
+	for {
+		bq := q
+		if last != nil {
+			bq = andQuery(q, map[string]interface{}{"_id": map[string]interface{}{"$gt": last}})
+		}
+		docs, err := f.readBatch(bq, batch)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			select {
+			case <-opts.Cancel:
+				return ErrCanceled
+			default:
+			}
+			id, _ := doc["_id"].(bson.ObjectId)
+			err := callback(doc, grabbed.New(f.set, id))
+			if err != nil {
+				return err
+			}
+			done++
+		}
+		if opts.Progress != nil && len(docs) > 0 {
+			opts.Progress(done, total)
+		}
+		if len(docs) < batch {
+			return nil
+		}
+		last = docs[len(docs) - 1]["_id"]
 	}
-	return nil
 }
 
 func (f *Filter) Insert(d map[string]interface{}) error {
+	if f.err != nil {
+		return f.err
+	}
 	i := mergeInsert(d, f.parents)
 	return f.set.Insert(i)
 }
 
 func (f *Filter) Update(upd_query map[string]interface{}) error {
+	if f.err != nil {
+		return f.err
+	}
 	q := mergeQuery(f.query, f.parents)
 	return f.set.Update(q, upd_query)
 }
 
 func (f *Filter) UpdateAll(upd_query map[string]interface{}) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
 	q := mergeQuery(f.query, f.parents)
 	return f.set.UpdateAll(q, upd_query)
 }
@@ -295,21 +655,25 @@ func (f *Filter) Subject() string {
 }
 
 func (f *Filter) Count() (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
 	q := mergeQuery(f.query, f.parents)
 	return f.set.Count(q)
 }
 
-func (f *Filter) AddParents(fieldname string, a []bson.ObjectId) {
-	slice, ok := f.parents[fieldname]
-	if !ok {
-		f.parents[fieldname] = []bson.ObjectId{}
-		slice = []bson.ObjectId{}
-	}
-	slice = append(slice, a...)
-	f.parents[fieldname] = slice
+// AddParents returns a new filter restricted to the documents having one of the ids a in the field fieldname.
+// The receiver is left intact.
+func (f *Filter) AddParents(fieldname string, a []bson.ObjectId) iface.Filter {
+	ret := f.clone()
+	ret.parents[fieldname] = append(ret.parents[fieldname], a...)
+	return ret
 }
 
 func (f *Filter) Ids() ([]bson.ObjectId, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
 	if val, has := f.query["id"]; has && len(f.query) == 1 && len(f.parents) == 1 {
 		ids := val.(map[string]interface{})["$in"].([]interface{})
 		ret := []bson.ObjectId{}
@@ -318,6 +682,9 @@ func (f *Filter) Ids() ([]bson.ObjectId, error) {
 		}
 		return ret, nil
 	}
+	f.set.Skip(0)
+	f.set.Limit(0)
+	f.set.Select(nil)
 	q := mergeQuery(f.query, f.parents)
 	docs, err := f.set.Find(q)
 	if err != nil {
@@ -331,11 +698,17 @@ func (f *Filter) Ids() ([]bson.ObjectId, error) {
 }
 
 func (f *Filter) Remove() error {
+	if f.err != nil {
+		return f.err
+	}
 	q := mergeQuery(f.query, f.parents)
 	return f.set.Remove(q)
 }
 
 func (f *Filter) RemoveAll() (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
 	q := mergeQuery(f.query, f.parents)
 	return f.set.RemoveAll(q)
 }
\ No newline at end of file
@@ -1,9 +1,14 @@
 package filter_test
 
 import(
+	"fmt"
+	"github.com/opesun/chill/frame/errs"
 	"github.com/opesun/chill/frame/filter"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/memset"
 	"testing"
 	"labix.org/v2/mgo/bson"
+	"time"
 )
 
 type MockEvent struct {}
@@ -21,6 +26,7 @@ type TestSet struct {
 	lastQuery	map[string]interface{}
 	name		string
 	lastData	map[string]interface{}
+	sel			map[string]int
 }
 
 func (t *TestSet) Skip(i int) {
@@ -35,6 +41,10 @@ func (t *TestSet) Sort(s ...string) {
 	t.sort = s
 }
 
+func (t *TestSet) Select(sel map[string]int) {
+	t.sel = sel
+}
+
 func (t *TestSet) Name() string {
 	return t.name
 }
@@ -49,6 +59,16 @@ func (t *TestSet) Find(q map[string]interface{}) ([]interface{}, error) {
 	return nil, nil
 }
 
+func (t *TestSet) Iter(q map[string]interface{}, batch int) (iface.Cursor, error) {
+	t.lastQuery = q
+	return nil, fmt.Errorf("Not implemented.")
+}
+
+func (t *TestSet) Aggregate(q map[string]interface{}, agg iface.Aggregation) ([]map[string]interface{}, error) {
+	t.lastQuery = q
+	return nil, nil
+}
+
 func (t *TestSet) Insert(d map[string]interface{}) error {
 	t.lastData = d
 	return nil
@@ -95,7 +115,8 @@ func TestMods(t *testing.T) {
 	if set.skip != 3 {
 		t.Fatal(set.limit)
 	}
-	if len(set.sort) != 2 || set.sort[0] != "x" || set.sort[1] != "y" {
+	// The _id breaks the ties, see the cursors.
+	if len(set.sort) != 3 || set.sort[0] != "x" || set.sort[1] != "y" || set.sort[2] != "_id" {
 		t.Fatal(set.sort)
 	}
 }
@@ -159,16 +180,44 @@ func TestCloneQuery(t *testing.T) {
 	}
 }
 
+func TestCloneIsDeep(t *testing.T) {
+	set := &TestSet{}
+	ev := &MockEvent{}
+	f := filter.New(set, ev, map[string]interface{}{"sort": "-x", "fields": "title"})
+	f = f.AddQuery(map[string]interface{}{"tags": map[string]interface{}{"$in": []interface{}{"a"}}}).(*filter.Filter)
+	f1 := f.Clone()
+	f1.Find()
+	q := set.lastQuery["tags"].(map[string]interface{})
+	q["$in"] = append(q["$in"].([]interface{}), "b")
+	q["$nin"] = []interface{}{"c"}
+	f1.Modifiers().Sort()[0] = "y"
+	f.Find()
+	in := set.lastQuery["tags"].(map[string]interface{})
+	if len(in) != 1 || len(in["$in"].([]interface{})) != 1 || set.sort[0] != "-x" {
+		t.Fatal(set.lastQuery, set.sort)
+	}
+	// AddQuery returns a new filter with both queries.
+	f2 := f.AddQuery(map[string]interface{}{"another_crit": "y"})
+	f2.Find()
+	if len(set.lastQuery) != 2 {
+		t.Fatal(set.lastQuery)
+	}
+	f.Find()
+	if len(set.lastQuery) != 1 {
+		t.Fatal(set.lastQuery)
+	}
+}
+
 func TestParents(t *testing.T) {
 	set := &TestSet{}
 	ev := &MockEvent{}
 	inp := map[string]interface{}{
 		"crit": 	"x",
 	}
-	f := filter.New(set, ev, inp)
+	orig := filter.New(set, ev, inp)
 	// Field referencing other collection
 	fieldname := "fname"
-	f.AddParents(fieldname, []bson.ObjectId{bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()})
+	f := orig.AddParents(fieldname, []bson.ObjectId{bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()})
 	f.Find()
 	if len(set.lastQuery) != 2 || len(set.lastQuery[fieldname].(map[string]interface{})["$in"].([]bson.ObjectId)) != 3 {
 		t.Fatal(set.lastQuery)
@@ -182,6 +231,11 @@ func TestParents(t *testing.T) {
 	if len(set.lastData) != 2 || len(set.lastData[fieldname].([]bson.ObjectId)) != 3 {
 		t.Fatal(set.lastData)
 	}
+	// The original filter is left intact.
+	orig.Find()
+	if len(set.lastQuery) != 1 {
+		t.Fatal(set.lastQuery)
+	}
 }
 
 func TestAddQuerySafety(t *testing.T) {
@@ -199,4 +253,522 @@ func TestAddQuerySafety(t *testing.T) {
 	if len(set.lastQuery) != 1 || set.lastQuery["crit"] != "x" {
 		t.Fatal(set.lastQuery)
 	}
-}
\ No newline at end of file
+}
+var allowed = filter.Allowed{"price": "number", "name": "string", "tags": "string", "deleted": "bool"}
+
+func TestOperators(t *testing.T) {
+	set := &TestSet{}
+	ev := &MockEvent{}
+	inp := map[string]interface{}{
+		"price>":			"10",
+		"price.lt":			"20.5",
+		"name.prefix":		"a.b",
+		"tags.all":			[]interface{}{"x,y", "z"},
+		"deleted.exists":	"false",
+		"color":			"red",
+	}
+	f := filter.NewAllowed(set, ev, inp, allowed, []string{"color"})
+	_, err := f.Find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := set.lastQuery
+	if len(q) != 5 || q["color"] != "red" {
+		t.Fatal(q)
+	}
+	price := q["price"].(map[string]interface{})
+	if price["$gte"] != 10.0 || price["$lt"] != 20.5 {
+		t.Fatal(price)
+	}
+	if q["name"].(map[string]interface{})["$regex"] != `^a\.b` {
+		t.Fatal(q["name"])
+	}
+	if len(q["tags"].(map[string]interface{})["$all"].([]interface{})) != 3 {
+		t.Fatal(q["tags"])
+	}
+	if q["deleted"].(map[string]interface{})["$exists"] != false {
+		t.Fatal(q["deleted"])
+	}
+}
+
+func TestOperatorInKey(t *testing.T) {
+	set := &TestSet{}
+	f := filter.NewAllowed(set, &MockEvent{}, map[string]interface{}{"price<3": ""}, allowed, nil)
+	f.Find()
+	if set.lastQuery["price"].(map[string]interface{})["$lt"] != 3.0 {
+		t.Fatal(set.lastQuery)
+	}
+}
+
+func TestOrGroups(t *testing.T) {
+	set := &TestSet{}
+	inp := map[string]interface{}{
+		"or.a.price.lt":	"5",
+		"or.a.deleted":		"false",
+		"or.b.name":		"ab",
+		"name":				"cd",
+		"name.ne":			"ef",
+	}
+	f := filter.NewAllowed(set, &MockEvent{}, inp, allowed, nil)
+	_, err := f.Find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	or := set.lastQuery["$or"].([]interface{})
+	if len(or) != 2 {
+		t.Fatal(or)
+	}
+	a := or[0].(map[string]interface{})
+	if a["price"].(map[string]interface{})["$lt"] != 5.0 || a["deleted"].(map[string]interface{})["$eq"] != false {
+		t.Fatal(a)
+	}
+	// Equality and operators on the same field.
+	name := set.lastQuery["name"].(map[string]interface{})
+	if len(name) != 2 || name["$eq"] != "cd" || name["$ne"] != "ef" {
+		t.Fatal(name)
+	}
+}
+
+func TestOperatorNotAllowed(t *testing.T) {
+	bad := []map[string]interface{}{
+		{"color.ne": "red"},
+		{"or.a.color": "red"},
+		{"price>": "cheap"},
+		{"deleted.exists": "maybe"},
+		{"or.price": "1"},
+	}
+	for _, v := range bad {
+		set := &TestSet{}
+		f := filter.NewAllowed(set, &MockEvent{}, v, allowed, nil)
+		if _, err := f.Find(); err == nil {
+			t.Fatal(v, set.lastQuery)
+		}
+	}
+	// No operators at all without an allowlist.
+	f := filter.NewAllowed(&TestSet{}, &MockEvent{}, map[string]interface{}{"price.gt": "1"}, nil, []string{"price"})
+	if _, err := f.Find(); err == nil {
+		t.Fatal()
+	}
+}
+
+// Adds raw operators like fulltext.ProcessMap does.
+type HookEvent struct {
+	MockEvent
+}
+
+func (h HookEvent) Fire(s string, params ...interface{}) {
+	inp := params[0].(map[string]interface{})
+	if val, has := inp["search"]; has {
+		inp["$and"] = []interface{}{map[string]interface{}{"words": val}}
+		delete(inp, "search")
+	}
+}
+
+func TestRawOperatorsRejected(t *testing.T) {
+	set := &TestSet{}
+	inp := map[string]interface{}{
+		"$where":	"sleep(1000)",
+		"$or":		[]interface{}{"x"},
+		"name":		map[string]interface{}{"$ne": ""},
+		"color":	"red",
+		"secret":	"x",
+		"id":		"AAAAAAAAAAAAAAAA",
+		"price":	"10",
+	}
+	f := filter.NewAllowed(set, &MockEvent{}, inp, allowed, []string{"color"})
+	_, err := f.Find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.lastQuery) != 3 || set.lastQuery["color"] != "red" || set.lastQuery["price"].(map[string]interface{})["$eq"] != 10.0 {
+		t.Fatal(set.lastQuery)
+	}
+	if _, has := set.lastQuery["_id"]; !has {
+		t.Fatal(set.lastQuery)
+	}
+}
+
+func TestHookOperatorsTrusted(t *testing.T) {
+	set := &TestSet{}
+	inp := map[string]interface{}{
+		"search":	"hello",
+		"$and":		[]interface{}{map[string]interface{}{"admin": true}},
+	}
+	f := filter.NewAllowed(set, HookEvent{}, inp, allowed, nil)
+	_, err := f.Find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	and := set.lastQuery["$and"].([]interface{})
+	if len(set.lastQuery) != 1 || len(and) != 1 || and[0].(map[string]interface{})["words"] != "hello" {
+		t.Fatal(set.lastQuery)
+	}
+	// Code can still use the raw operators.
+	f = filter.New(set, &MockEvent{}, map[string]interface{}{"price": map[string]interface{}{"$gt": 1}})
+	f.AddQuery(map[string]interface{}{"$or": []interface{}{}}).Find()
+	if len(set.lastQuery) != 2 {
+		t.Fatal(set.lastQuery)
+	}
+}
+
+func TestFields(t *testing.T) {
+	set := &TestSet{}
+	f := filter.New(set, &MockEvent{}, map[string]interface{}{"fields": []interface{}{"title, slug", "body"}})
+	f.Project([]string{"title"}, []string{"secret"})
+	f.Find()
+	if len(set.sel) != 3 || set.sel["title"] != 1 || set.sel["body"] != 1 {
+		t.Fatal(set.sel)
+	}
+	if fs := f.Modifiers().Fields(); len(fs) != 3 || fs[1] != "slug" {
+		t.Fatal(fs)
+	}
+	// The defaults are used if no fields are asked for, forbidden ones are left out.
+	f = filter.New(set, &MockEvent{}, nil).Project([]string{"title"}, []string{"secret"})
+	f.FindOne()
+	if len(set.sel) != 1 || set.sel["title"] != 1 {
+		t.Fatal(set.sel)
+	}
+	f = filter.New(set, &MockEvent{}, map[string]interface{}{"fields": "-body"}).Project(nil, []string{"secret"})
+	f.Find()
+	if len(set.sel) != 2 || set.sel["body"] != 0 || set.sel["secret"] != 0 {
+		t.Fatal(set.sel)
+	}
+	bad := []string{"secret", "title,-body"}
+	for _, v := range bad {
+		f = filter.New(set, &MockEvent{}, map[string]interface{}{"fields": v}).Project(nil, []string{"secret"})
+		if _, err := f.Find(); err == nil {
+			t.Fatal(v)
+		}
+	}
+	// Nor can the forbidden and hidden fields be sorted by, the sort keys would be returned.
+	for _, v := range []string{"secret", "-secret.x", "password"} {
+		f = filter.New(set, &MockEvent{}, map[string]interface{}{"fields": "title", "sort": v}).Project(nil, []string{"secret"})
+		if _, err := f.Find(); !errs.Is(err, errs.BadInput) {
+			t.Fatal(v, err)
+		}
+	}
+	// Ids need the whole documents.
+	f.Ids()
+	if set.sel != nil {
+		t.Fatal(set.sel)
+	}
+}
+
+func TestCursorQuery(t *testing.T) {
+	set := &TestSet{}
+	f := filter.New(set, &MockEvent{}, map[string]interface{}{"sort": "-created", "limit": 10, "skip": 20, "fields": "title"})
+	f.Find()
+	// Without a cursor the query is left alone, apart from the _id breaking the ties.
+	if set.limit != 10 || set.skip != 20 || len(set.sort) != 2 || set.sort[1] != "_id" || len(set.lastQuery) != 0 {
+		t.Fatal(set)
+	}
+	// The sort keys are needed to build the cursors.
+	if set.sel["created"] != 1 {
+		t.Fatal(set.sel)
+	}
+	inp := map[string]interface{}{"sort": "-created", "limit": 10, "skip": 20, "after": "nonsense"}
+	if _, err := filter.New(set, &MockEvent{}, inp).Find(); err == nil {
+		t.Fatal(err)
+	}
+	prev, next := filter.New(set, &MockEvent{}, nil).Cursors()
+	if prev != "" || next != "" {
+		t.Fatal(prev, next)
+	}
+}
+
+func fill(t *testing.T, docs ...map[string]interface{}) *memset.Set {
+	set := memset.New(memset.NewDb(), "cars").(*memset.Set)
+	for _, v := range docs {
+		err := set.Insert(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return set
+}
+
+func makes(docs []interface{}) []string {
+	ret := []string{}
+	for _, v := range docs {
+		ret = append(ret, v.(map[string]interface{})["make"].(string))
+	}
+	return ret
+}
+
+func TestCursors(t *testing.T) {
+	set := fill(t,
+		map[string]interface{}{"make": "bmw", "year": 2001},
+		map[string]interface{}{"make": "audi", "year": 1999},
+		map[string]interface{}{"make": "fiat", "year": 2010},
+		map[string]interface{}{"make": "opel", "year": 2001},
+		map[string]interface{}{"make": "seat", "year": 2001},
+	)
+	page := func(cursor map[string]interface{}) ([]string, string, string) {
+		inp := map[string]interface{}{"sort": "-year", "limit": 2}
+		for i, v := range cursor {
+			inp[i] = v
+		}
+		f := filter.New(set, &MockEvent{}, inp)
+		docs, err := f.Find()
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev, next := f.Cursors()
+		return makes(docs), prev, next
+	}
+	seen := []string{}
+	res, prev, next := page(nil)
+	if prev != "" || next == "" {
+		t.Fatal(prev, next)
+	}
+	seen = append(seen, res...)
+	res, prev, next = page(map[string]interface{}{"after": next})
+	if prev == "" || next == "" {
+		t.Fatal(prev, next)
+	}
+	seen = append(seen, res...)
+	res, _, last := page(map[string]interface{}{"after": next})
+	seen = append(seen, res...)
+	// The ties on the year are broken by the _id, so no document is skipped or repeated.
+	if len(seen) != 5 || seen[0] != "fiat" || seen[4] != "audi" || last != "" {
+		t.Fatal(seen, last)
+	}
+	set_of := map[string]bool{}
+	for _, v := range seen {
+		set_of[v] = true
+	}
+	if len(set_of) != 5 {
+		t.Fatal(seen)
+	}
+	// Going back from the second page.
+	res, back_prev, back_next := page(map[string]interface{}{"before": prev})
+	if len(res) != 2 || res[0] != seen[0] || res[1] != seen[1] || back_prev != "" || back_next == "" {
+		t.Fatal(res, back_prev, back_next)
+	}
+	// Bad cursors.
+	f := filter.New(set, &MockEvent{}, map[string]interface{}{"after": "nonsense"})
+	if _, err := f.Find(); !errs.Is(err, errs.BadInput) {
+		t.Fatal(err)
+	}
+	f = filter.New(set, &MockEvent{}, map[string]interface{}{"after": next, "sort": []interface{}{"-year", "make"}})
+	if _, err := f.Find(); !errs.Is(err, errs.BadInput) {
+		t.Fatal(err)
+	}
+	f = filter.New(set, &MockEvent{}, map[string]interface{}{"after": next, "before": prev})
+	if _, err := f.Find(); !errs.Is(err, errs.BadInput) {
+		t.Fatal(err)
+	}
+}
+
+// The first page has to be sorted the same way as the ones reached by the cursors, even if the ties are stored in
+// an other order than their _ids.
+func TestCursorsTies(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e", "f", "g"}
+	ids := []bson.ObjectId{}
+	for range names {
+		ids = append(ids, bson.NewObjectId())
+	}
+	docs := []map[string]interface{}{}
+	for i := len(names) - 1; i >= 0; i-- {
+		docs = append(docs, map[string]interface{}{"_id": ids[i], "make": names[i], "year": 2001})
+	}
+	set := fill(t, docs...)
+	seen := map[string]int{}
+	next := ""
+	for i := 0; i < len(names); i++ {
+		inp := map[string]interface{}{"sort": "year", "limit": 2}
+		if next != "" {
+			inp["after"] = next
+		}
+		f := filter.New(set, &MockEvent{}, inp)
+		res, err := f.Find()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range makes(res) {
+			seen[v]++
+		}
+		_, next = f.Cursors()
+		if next == "" {
+			break
+		}
+	}
+	if len(seen) != len(names) {
+		t.Fatal(seen)
+	}
+	for i, v := range seen {
+		if v != 1 {
+			t.Fatal(i, v)
+		}
+	}
+}
+
+func TestRelations(t *testing.T) {
+	db := memset.NewDb()
+	ev := &MockEvent{}
+	rels := filter.Relations{
+		"posts":	{"blog_id": "blogs", "tag_ids": "tags"},
+		"comments":	{"post_id": "posts"},
+	}
+	newf := func(coll string, q map[string]interface{}) *filter.Filter {
+		return filter.New(memset.New(db, coll), ev, q).Relate(rels)
+	}
+	blog, other := bson.NewObjectId(), bson.NewObjectId()
+	go_tag, db_tag := bson.NewObjectId(), bson.NewObjectId()
+	memset.New(db, "blogs").Insert(map[string]interface{}{"_id": blog})
+	memset.New(db, "blogs").Insert(map[string]interface{}{"_id": other})
+	memset.New(db, "tags").Insert(map[string]interface{}{"_id": go_tag, "name": "go"})
+	memset.New(db, "tags").Insert(map[string]interface{}{"_id": db_tag, "name": "db"})
+	posts := memset.New(db, "posts")
+	p1, p2, p3 := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+	posts.Insert(map[string]interface{}{"_id": p1, "blog_id": blog, "tag_ids": []interface{}{go_tag}})
+	posts.Insert(map[string]interface{}{"_id": p2, "blog_id": blog, "tag_ids": []interface{}{go_tag, db_tag}})
+	posts.Insert(map[string]interface{}{"_id": p3, "blog_id": other, "tag_ids": []interface{}{db_tag}})
+	comments := memset.New(db, "comments")
+	comments.Insert(map[string]interface{}{"post_id": p1, "text": "a"})
+	comments.Insert(map[string]interface{}{"post_id": p2, "text": "b"})
+	comments.Insert(map[string]interface{}{"post_id": p3, "text": "c"})
+	// /blogs/<id>/posts/comments
+	red, err := newf("blogs", map[string]interface{}{"_id": blog}).Reduce(newf("posts", nil), newf("comments", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := red.Find()
+	if err != nil || len(docs) != 2 {
+		t.Fatal(docs, err)
+	}
+	// Many-to-many, from the side holding the ids: /posts/<id>/tags
+	red, err = newf("posts", map[string]interface{}{"_id": p2}).Reduce(newf("tags", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := red.Count(); c != 2 || err != nil {
+		t.Fatal(c, err)
+	}
+	// The query of the child can't widen the relation.
+	red, _ = newf("posts", map[string]interface{}{"_id": p1}).Reduce(newf("tags", map[string]interface{}{"_id": db_tag}))
+	if c, err := red.Count(); c != 0 || err != nil {
+		t.Fatal(c, err)
+	}
+	// And from the other side: /tags/<id>/posts/comments
+	red, err = newf("tags", map[string]interface{}{"_id": db_tag}).Reduce(newf("posts", nil), newf("comments", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err = red.Find()
+	if err != nil || len(docs) != 2 {
+		t.Fatal(docs, err)
+	}
+	// Inserting through a declared relation.
+	red, _ = newf("posts", map[string]interface{}{"_id": p3}).Reduce(newf("comments", nil))
+	if err := red.Insert(map[string]interface{}{"text": "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := newf("comments", map[string]interface{}{"post_id": p3}).Count(); c != 2 {
+		t.Fatal(c)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	set := memset.New(memset.NewDb(), "cars")
+	for i := 0; i < 7; i++ {
+		set.Insert(map[string]interface{}{"year": 2000 + i})
+	}
+	f := filter.New(set, &MockEvent{}, nil)
+	progress := [][2]int{}
+	opts := &iface.IterOptions{
+		Batch: 3,
+		Progress: func(done, total int) {
+			progress = append(progress, [2]int{done, total})
+		},
+	}
+	years := map[interface{}]bool{}
+	err := f.Iterate(opts, func(doc map[string]interface{}, g iface.Grabbed) error {
+		years[doc["year"]] = true
+		return g.Update(map[string]interface{}{"$set": map[string]interface{}{"year": 0}})
+	})
+	if err != nil || len(years) != 7 {
+		t.Fatal(years, err)
+	}
+	if len(progress) != 3 || progress[0] != [2]int{3, 7} || progress[2] != [2]int{7, 7} {
+		t.Fatal(progress)
+	}
+	// Cancellation.
+	cancel := make(chan struct{})
+	visits := 0
+	err = f.Iterate(&iface.IterOptions{Batch: 2, Cancel: cancel}, func(doc map[string]interface{}, g iface.Grabbed) error {
+		visits++
+		if visits == 3 {
+			close(cancel)
+		}
+		return nil
+	})
+	if err != filter.ErrCanceled || visits != 3 {
+		t.Fatal(visits, err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	day, base := int64(86400), int64(1368000000)		// 2013-05-08 08:00 UTC
+	set := fill(t,
+		map[string]interface{}{"author": "ann", "total": 10, "tags": []interface{}{"go", "db"}, "created": base},
+		map[string]interface{}{"author": "ann", "total": 5.5, "tags": []interface{}{"go"}, "created": base + 60},
+		map[string]interface{}{"author": "bob", "total": 1, "created": time.Unix(base + day, 0)},
+		map[string]interface{}{"author": "cid", "total": 3, "tags": []interface{}{"db"}, "created": (base + day) * 1e9, "password": "x"},
+	)
+	agg, err := filter.ParseAggregation(map[string]interface{}{"by": "author", "ops": []interface{}{"count,sum:total", "max:total"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := filter.New(set, &MockEvent{}, map[string]interface{}{"sort": "-count", "limit": 2}).Aggregate(agg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["author"] != "ann" || rows[0]["count"] != 2 || rows[0]["sum_total"] != 15.5 || rows[0]["max_total"] != 10 {
+		t.Fatal(rows)
+	}
+	// Date buckets, the timestamps can be given in seconds, nanoseconds or as dates.
+	agg, _ = filter.ParseAggregation(map[string]interface{}{"by": "created:day"})
+	rows, err = filter.New(set, &MockEvent{}, nil).Aggregate(agg)
+	if err != nil || len(rows) != 2 || rows[0]["created"] != "2013-05-08" || rows[1]["count"] != 2 {
+		t.Fatal(rows, err)
+	}
+	// Array fields are unwound.
+	agg, _ = filter.ParseAggregation(map[string]interface{}{"by": "tags", "ops": "distinct:author"})
+	rows, err = filter.New(set, &MockEvent{}, map[string]interface{}{"total": 10}).Aggregate(agg)
+	if err != nil || len(rows) != 2 || rows[0]["tags"] != "db" || len(rows[0]["distinct_author"].([]interface{})) != 1 {
+		t.Fatal(rows, err)
+	}
+	agg, _ = filter.ParseAggregation(map[string]interface{}{"ops": "distinct:tags,avg:total"})
+	rows, err = filter.New(set, &MockEvent{}, nil).Aggregate(agg)
+	if err != nil || len(rows) != 1 || len(rows[0]["distinct_tags"].([]interface{})) != 2 || rows[0]["avg_total"] != 4.875 {
+		t.Fatal(rows, err)
+	}
+	// The default limit of Find is not applied to the rows.
+	many := fill(t)
+	for i := 0; i < 25; i++ {
+		many.Insert(map[string]interface{}{"author": i})
+	}
+	agg, _ = filter.ParseAggregation(map[string]interface{}{"by": "author"})
+	rows, err = filter.New(many, &MockEvent{}, nil).Aggregate(agg)
+	if err != nil || len(rows) != 25 {
+		t.Fatal(len(rows), err)
+	}
+	rows, err = filter.New(many, &MockEvent{}, map[string]interface{}{"page": 2}).Aggregate(agg)
+	if err != nil || len(rows) != 5 || rows[0]["author"] != 20 {
+		t.Fatal(rows, err)
+	}
+	// Hidden fields can't be aggregated, and the specification is checked.
+	agg, _ = filter.ParseAggregation(map[string]interface{}{"ops": "distinct:password"})
+	if _, err := filter.New(set, &MockEvent{}, nil).Aggregate(agg); !errs.Is(err, errs.BadInput) {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"median:total", "sum", "count:x,max"} {
+		if _, err := filter.ParseAggregation(map[string]interface{}{"ops": v}); !errs.Is(err, errs.BadInput) {
+			t.Fatal(v, err)
+		}
+	}
+	if _, err := filter.ParseAggregation(map[string]interface{}{"by": "created:week"}); !errs.Is(err, errs.BadInput) {
+		t.Fatal(err)
+	}
+}
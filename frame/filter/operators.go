@@ -0,0 +1,267 @@
+package filter
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/misc/convert"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Allowed lists the fields of a noun which can be filtered with operators, field name => type of the values.
+// The types are "string", "number", "int", "bool" and "id", the values coming from the URL are converted accordingly.
+// Declared in the options as nouns.X.filters, eg.
+//	"filters": {"price": "number", "name": "string", "tags": "string", "deleted": "bool"}
+// Operator syntax in the URL query:
+//	price>=10, price<=10, price!=10, price>10, price<10
+//	price.gt=10, price.gte=10, price.lt=10, price.lte=10, price.eq=10, price.ne=10
+//	tags.in=a,b  tags.nin=a,b  tags.all=a,b
+//	deleted.exists=false
+//	name.prefix=ab
+//	or.1.price.lt=5&or.1.deleted.exists=false&or.2.name=ab	-> price < 5 and deleted is missing, or name is ab
+// The fields of the allowlist can be filtered by equality too, with their values converted the same way, see NewAllowed.
+type Allowed map[string]string
+
+var Types = []string{"string", "number", "int", "bool", "id"}
+
+var operators = map[string]string{
+	"eq":		"$eq",
+	"ne":		"$ne",
+	"gt":		"$gt",
+	"gte":		"$gte",
+	"lt":		"$lt",
+	"lte":		"$lte",
+	"in":		"$in",
+	"nin":		"$nin",
+	"all":		"$all",
+	"exists":	"$exists",
+	"prefix":	"$regex",
+}
+
+// The operators taking a list of values.
+var list_ops = map[string]bool{"$in": true, "$nin": true, "$all": true}
+
+// Splits an operator key into a field and an operator. The forms without a "=", eg. "price>10" come with their value in the key.
+// Returns an empty op if key is not an operator key.
+func splitKey(key string) (field, op string, val *string) {
+	if i := strings.IndexAny(key, "<>!"); i > 0 {
+		field, rest := key[:i], key[i:]
+		switch {
+		case rest == ">" || strings.HasPrefix(rest, ">="):
+			op = "$gte"
+		case rest == "<" || strings.HasPrefix(rest, "<="):
+			op = "$lte"
+		case rest == "!" || strings.HasPrefix(rest, "!="):
+			op = "$ne"
+		case rest[0] == '>':
+			op = "$gt"
+		case rest[0] == '<':
+			op = "$lt"
+		default:
+			return "", "", nil
+		}
+		if len(rest) > 1 {
+			v := strings.TrimPrefix(rest[1:], "=")
+			val = &v
+		}
+		return field, op, val
+	}
+	if i := strings.LastIndex(key, "."); i > 0 {
+		if op, has := operators[key[i+1:]]; has {
+			return key[:i], op, nil
+		}
+	}
+	return "", "", nil
+}
+
+func values(v interface{}) []interface{} {
+	switch val := v.(type) {
+	case []interface{}:
+		return val
+	case []string:
+		ret := []interface{}{}
+		for _, x := range val {
+			ret = append(ret, x)
+		}
+		return ret
+	}
+	return []interface{}{v}
+}
+
+// Converts a value coming from the URL to typ.
+func convertValue(typ string, v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil		// Already typed, eg. coming from code.
+	}
+	switch typ {
+	case "number":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a number.", s)
+		}
+		return f, nil
+	case "int":
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not an integer.", s)
+		}
+		return i, nil
+	case "bool":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a boolean.", s)
+		}
+		return b, nil
+	case "id":
+		return convert.DecodeId(s)
+	}
+	return s, nil
+}
+
+// Builds the value of op from the raw values.
+func opValue(typ, op string, raw []interface{}) (interface{}, error) {
+	if list_ops[op] {
+		ret := []interface{}{}
+		for _, v := range raw {
+			parts := []interface{}{v}
+			if s, ok := v.(string); ok {
+				parts = nil
+				for _, p := range strings.Split(s, ",") {
+					parts = append(parts, p)
+				}
+			}
+			for _, p := range parts {
+				c, err := convertValue(typ, p)
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, c)
+			}
+		}
+		return ret, nil
+	}
+	if len(raw) != 1 {
+		return nil, fmt.Errorf("Operator must have exactly one value.")
+	}
+	switch op {
+	case "$exists":
+		return convertValue("bool", raw[0])
+	case "$regex":
+		s, ok := raw[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("Prefix must be a string.")
+		}
+		return "^" + regexp.QuoteMeta(s), nil
+	}
+	return convertValue(typ, raw[0])
+}
+
+// Adds a single operator key to q, which is field => operator map.
+// Plain keys are accepted too as equality (or $in for multiple values), used in the or groups.
+func addOp(q map[string]interface{}, allowed Allowed, key string, val interface{}) error {
+	field, op, inline := splitKey(key)
+	raw := values(val)
+	if op == "" {
+		field = key
+		op = "$eq"
+		if len(raw) > 1 {
+			op = "$in"
+		}
+	} else if inline != nil {
+		raw = []interface{}{*inline}
+	}
+	typ, ok := allowed[field]
+	if !ok {
+		return errs.New(errs.BadInput, "Field %v can't be filtered.", field)
+	}
+	v, err := opValue(typ, op, raw)
+	if err != nil {
+		return errs.New(errs.BadInput, "Bad filter %v: %v", key, err)
+	}
+	ops, ok := q[field].(map[string]interface{})
+	if !ok {
+		ops = map[string]interface{}{}
+		q[field] = ops
+	}
+	if _, has := ops[op]; has {
+		return errs.New(errs.BadInput, "Field %v is filtered with the same operator more than once.", field)
+	}
+	ops[op] = v
+	return nil
+}
+
+// Parses the operator keys and the or groups, and removes them from inp.
+// The result is a query which can be merged with the rest of the query with mergeOps.
+func parseOps(inp map[string]interface{}, allowed Allowed) (map[string]interface{}, []interface{}, error) {
+	q := map[string]interface{}{}
+	groups := map[string]map[string]interface{}{}
+	keys := []string{}
+	for i := range inp {
+		keys = append(keys, i)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		val := inp[key]
+		if strings.HasPrefix(key, "or.") {
+			parts := strings.SplitN(key, ".", 3)
+			if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+				return nil, nil, errs.New(errs.BadInput, "Or groups must look like or.<group>.<field>, got %v.", key)
+			}
+			g, has := groups[parts[1]]
+			if !has {
+				g = map[string]interface{}{}
+				groups[parts[1]] = g
+			}
+			if err := addOp(g, allowed, parts[2], val); err != nil {
+				return nil, nil, err
+			}
+			delete(inp, key)
+			continue
+		}
+		if _, op, _ := splitKey(key); op == "" {
+			if _, typed := allowed[key]; !typed {
+				continue
+			}
+		}
+		if err := addOp(q, allowed, key, val); err != nil {
+			return nil, nil, err
+		}
+		delete(inp, key)
+	}
+	names := []string{}
+	for i := range groups {
+		names = append(names, i)
+	}
+	sort.Strings(names)
+	or := []interface{}{}
+	for _, v := range names {
+		or = append(or, groups[v])
+	}
+	return q, or, nil
+}
+
+// Merges the operator query into q. Fields filtered both by equality and operators are put into an $and.
+func mergeOps(q, ops map[string]interface{}, or []interface{}) {
+	and, _ := q["$and"].([]interface{})
+	for field, v := range ops {
+		if eq, has := q[field]; has {
+			and = append(and, map[string]interface{}{field: eq}, map[string]interface{}{field: v})
+			delete(q, field)
+		} else {
+			q[field] = v
+		}
+	}
+	if len(or) > 0 {
+		if _, has := q["$or"]; has {
+			and = append(and, map[string]interface{}{"$or": or})
+		} else {
+			q["$or"] = or
+		}
+	}
+	if len(and) > 0 {
+		q["$and"] = and
+	}
+}
@@ -0,0 +1,113 @@
+package filter
+
+import(
+	"fmt"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"labix.org/v2/mgo/bson"
+	"sort"
+)
+
+// Relations tells how the nouns relate to each other, noun => field => the noun whose ids are stored in the field.
+// Declared in the options as nouns.X.relations, eg.
+//	"comments":	{"relations": {"post_id": "posts"}}
+//	"posts":	{"relations": {"blog_id": "blogs", "tag_ids": "tags"}}
+// A field can hold a single id or a list of ids, the latter makes many-to-many relations possible. Relations can be followed
+// in both directions, so with the above both /tags/<id>/posts and /posts/<id>/tags work.
+// Nouns without a declared relation are linked through the "_<parent noun>" field.
+type Relations map[string]map[string]string
+
+// The first field of noun (in alphabetical order) referencing to.
+func (r Relations) field(noun, to string) string {
+	fields := []string{}
+	for i, v := range r[noun] {
+		if v == to {
+			fields = append(fields, i)
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	sort.Strings(fields)
+	return fields[0]
+}
+
+// Tells how the documents of child are linked to the ones of parent. If reverse is false, the ids of the parents are stored
+// in field of the children, otherwise the ids of the children are stored in field of the parents.
+func (r Relations) link(parent, child string) (field string, reverse bool) {
+	if f := r.field(child, parent); f != "" {
+		return f, false
+	}
+	if f := r.field(parent, child); f != "" {
+		return f, true
+	}
+	return "_" + parent, false
+}
+
+func refIds(val interface{}) []bson.ObjectId {
+	switch v := val.(type) {
+	case bson.ObjectId:
+		return []bson.ObjectId{v}
+	case []bson.ObjectId:
+		return v
+	case []interface{}:
+		ret := []bson.ObjectId{}
+		for _, x := range v {
+			if id, ok := x.(bson.ObjectId); ok {
+				ret = append(ret, id)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+// Restricts child to the documents related to the ones matched by parent.
+func (r Relations) reduce(parent, child iface.Filter) (iface.Filter, error) {
+	field, reverse := r.link(parent.Subject(), child.Subject())
+	if !reverse {
+		ids, err := parent.Ids()
+		if err != nil {
+			return nil, err
+		}
+		return child.AddParents(field, ids), nil
+	}
+	ids := []bson.ObjectId{}
+	seen := map[bson.ObjectId]bool{}
+	err := parent.Iterate(nil, func(doc map[string]interface{}, _ iface.Grabbed) error {
+		for _, id := range refIds(doc[field]) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return child.AddParents("_id", ids), nil
+}
+
+// Reduce restricts the filters one after the other to the documents related to the ones matched by the previous filter,
+// starting with f, eg. for /blogs/<id>/posts/comments the comments of the posts of the blog. Returns the last filter.
+func (f *Filter) Reduce(a ...iface.Filter) (iface.Filter, error) {
+	if len(a) == 0 {
+		return nil, fmt.Errorf("Nothing to reduce.")
+	}
+	var prev iface.Filter
+	prev = f
+	for _, v := range a {
+		red, err := f.rels.reduce(prev, v)
+		if err != nil {
+			return nil, err
+		}
+		prev = red
+	}
+	return prev, nil
+}
+
+// Relate sets the relations used by Reduce.
+func (f *Filter) Relate(rels Relations) *Filter {
+	f.rels = rels
+	return f
+}
@@ -7,6 +7,9 @@ import(
 	"fmt"
 	iface "github.com/opesun/chill/frame/interfaces"
 	"github.com/opesun/chill/frame/verbinfo"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/jsonp"
+	"strings"
 )
 
 type Descriptor struct {
@@ -47,6 +50,83 @@ func Identify(path string, nouns map[string]interface{}, inp map[string]interfac
 	return desc, nil
 }
 
+// REST style mapping of the HTTP methods, for requests targeting a noun and not a verb:
+// DELETE /cars/:id => Remove, PUT or PATCH /cars/:id => Update, POST /cars => Insert.
+// Returns empty string if the request should be routed as usual.
+func MethodVerb(method string, s *lang.Sentence) string {
+	switch {
+	case method == "DELETE" && s.Verb == "GetSingle":
+		return "Remove"
+	case (method == "PUT" || method == "PATCH") && s.Verb == "GetSingle":
+		return "Update"
+	case method == "POST" && s.Verb == "Get":
+		return "Insert"
+	}
+	return ""
+}
+
+// Identifies a path pointing to a noun, then appends verb to it. Query (coming from the url) is used to build the filters,
+// while the body of the request will be the input data of the verb.
+func IdentifyWithVerb(path string, nouns map[string]interface{}, query, body map[string]interface{}, verb string) (*Descriptor, error) {
+	desc, err := Identify(path, nouns, query)
+	if err != nil {
+		return nil, err
+	}
+	spkr := speaker.New(moduleHasVerb, nouns)
+	loc := spkr.VerbLocation(desc.Sentence.Noun, verb)
+	if loc == "" {
+		return nil, errs.New(errs.NotFound, "Noun %v has no verb %v.", desc.Sentence.Noun, verb)
+	}
+	desc.Route.Words = append(desc.Route.Words, lang.ToURLStyle(verb))
+	desc.Route.Queries = append(desc.Route.Queries, body)
+	desc.Sentence.Verb = verb
+	desc.VerbLocation = loc
+	return desc, nil
+}
+
+// Tells if the verb only reads, only those can be called with a GET request, so the ones changing anything can not be
+// triggered by a plain link (or a crawler following it). The option document can declare it at nouns.X.verbs.Y.safe,
+// otherwise the module containing the verb is asked, which lists its read only verbs in its SafeVerbs method.
+func safe(s *lang.Sentence, nouns map[string]interface{}, ins iface.Instance) bool {
+	if is, has := jsonp.Get(nouns, fmt.Sprintf("%v.verbs.%v.safe", s.Noun, s.Verb)); has {
+		b, _ := is.(bool)
+		return b
+	}
+	if ins == nil || !ins.HasMethod("SafeVerbs") {
+		return false
+	}
+	var verbs []string
+	ret_rec := func(v []string) {
+		verbs = v
+	}
+	ins.Method("SafeVerbs").Call(ret_rec)
+	for _, v := range verbs {
+		if v == s.Verb {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the verb can be called with the given HTTP method. ins is the module containing the verb.
+// The allowed methods can be declared at nouns.X.verbs.Y.methods, eg. ["POST", "DELETE"], otherwise every method is allowed,
+// except GET and HEAD for the verbs which are not safe.
+func AllowedMethod(method string, s *lang.Sentence, nouns map[string]interface{}, ins iface.Instance) error {
+	methods, has := jsonp.GetS(nouns, fmt.Sprintf("%v.verbs.%v.methods", s.Noun, s.Verb))
+	if has {
+		for _, v := range methods {
+			if m, ok := v.(string); ok && strings.ToUpper(m) == method {
+				return nil
+			}
+		}
+		return errs.New(errs.MethodNotAllowed, "Method %v is not allowed for verb %v.", method, s.Verb)
+	}
+	if (method == "GET" || method == "HEAD") && !safe(s, nouns, ins) {
+		return errs.New(errs.MethodNotAllowed, "Method %v is not allowed for verb %v.", method, s.Verb)
+	}
+	return nil
+}
+
 // Returns error if input is not valid according to the rules found in d.nouns
 func (d *Descriptor) CreateInputs(filterCreator func(string, map[string]interface{})iface.Filter) ([]interface{}, map[string]interface{}, error) {
 	module := mod.NewModule(d.VerbLocation)
@@ -58,7 +138,7 @@ func (d *Descriptor) CreateInputs(filterCreator func(string, map[string]interfac
 	an := verbinfo.NewAnalyzer(verb)
 	ac := an.ArgCount()
 	if len(d.Route.Queries) < ac {
-		return nil, nil, fmt.Errorf("Not enough input to supply.")
+		return nil, nil, errs.New(errs.BadInput, "Not enough input to supply.")
 	}
 	if ac == 0 {
 		return nil, nil, nil
@@ -80,9 +160,6 @@ func (d *Descriptor) CreateInputs(filterCreator func(string, map[string]interfac
 		}
 	}
 	if fc > 0 {
-		if fc != 1 && len(source) != fc {
-			return nil, nil, fmt.Errorf("Got %v inputs, but method %v needs only %v filters. Currently filters can only be reduced to 1.", len(source), d.Sentence.Verb, fc)
-		}
 		filters := []iface.Filter{}
 		for i, v := range source {
 			if d.Sentence.Verb != "Get" && d.Sentence.Verb != "GetSingle" && i == len(source)-1 {
@@ -90,13 +167,18 @@ func (d *Descriptor) CreateInputs(filterCreator func(string, map[string]interfac
 			}
 			filters = append(filters, filterCreator(d.Route.Words[i], v))
 		}
-		if len(filters) > 1 {
-			filter := filters[0]
-			red, err := filter.Reduce(filters[1:]...)
+		if len(filters) < fc {
+			return nil, nil, errs.New(errs.BadInput, "Got %v filters, but method %v needs %v.", len(filters), d.Sentence.Verb, fc)
+		}
+		// The first fc-1 filters are passed as they are, the rest is reduced into the last one, eg. with a verb taking two filters
+		// /tags/<id>/blogs/<id>/posts gives the filter of the tag, and the one of the posts of the blog.
+		if lead := fc - 1; len(filters) - lead > 1 {
+			rest := filters[lead:]
+			red, err := rest[0].Reduce(rest[1:]...)
 			if err != nil {
 				return nil, nil, err
 			}
-			filters = []iface.Filter{red}
+			filters = append(filters[:lead], red)
 		}
 		for _, v := range filters {
 			inp = append(inp, v)
@@ -0,0 +1,82 @@
+package glue_test
+
+import(
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/glue"
+	"github.com/opesun/chill/frame/lang"
+	"github.com/opesun/chill/frame/mod"
+	"net/http"
+	"testing"
+)
+
+func TestMethodVerb(t *testing.T) {
+	cases := []struct{
+		method, verb, expected string
+	}{
+		{"DELETE", "GetSingle", "Remove"},
+		{"PUT", "GetSingle", "Update"},
+		{"PATCH", "GetSingle", "Update"},
+		{"POST", "Get", "Insert"},
+		{"POST", "Insert", ""},
+		{"DELETE", "Get", ""},
+		{"GET", "GetSingle", ""},
+	}
+	for _, v := range cases {
+		got := glue.MethodVerb(v.method, &lang.Sentence{Noun: "cars", Verb: v.verb})
+		if got != v.expected {
+			t.Fatal(v, got)
+		}
+	}
+}
+
+func TestAllowedMethod(t *testing.T) {
+	nouns := map[string]interface{}{
+		"cars": map[string]interface{}{
+			"verbs": map[string]interface{}{
+				"Remove": map[string]interface{}{
+					"methods": []interface{}{"delete"},
+				},
+				"Reindex": map[string]interface{}{
+					"methods": []interface{}{"get", "post"},
+				},
+				"Preview": map[string]interface{}{
+					"safe": true,
+				},
+				"New": map[string]interface{}{
+					"safe": false,
+				},
+			},
+		},
+	}
+	cases := []struct{
+		method, verb, module string
+		allowed bool
+	}{
+		{"GET", "Get", "skeleton", true},
+		{"GET", "Insert", "skeleton", false},
+		{"POST", "Insert", "skeleton", true},
+		{"POST", "Remove", "skeleton", false},
+		{"DELETE", "Remove", "skeleton", true},
+		{"GET", "Edit", "skeleton", true},
+		{"GET", "Login", "users", false},
+		{"GET", "LoginForm", "users", true},
+		{"HEAD", "Versions", "jsonedit", true},
+		{"POST", "Rollback", "jsonedit", true},
+		{"GET", "Rollback", "jsonedit", false},
+		{"GET", "RegenerateFulltext", "fulltext", false},
+		{"HEAD", "Logout", "users", false},
+		{"GET", "Reindex", "skeleton", true},
+		{"GET", "Preview", "skeleton", true},
+		{"GET", "New", "skeleton", false},
+	}
+	for _, v := range cases {
+		ins := mod.NewModule(v.module).Instance()
+		err := glue.AllowedMethod(v.method, &lang.Sentence{Noun: "cars", Verb: v.verb}, nouns, ins)
+		if (err == nil) != v.allowed {
+			t.Fatal(v, err)
+		}
+		if err != nil && errs.Status(err) != http.StatusMethodNotAllowed {
+			t.Fatal(v, err)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+// Package indexes keeps the indexes of the collections in sync with the ones declared in the option document.
+// The indexes of a noun are declared next to its verbs:
+//	"nouns": {
+//		"posts": {
+//			"composed_of": ["skeleton"],
+//			"indexes": [
+//				{"key": ["slug"], "unique": true},
+//				{"key": ["author", "-created"]},
+//				{"key": ["email"], "unique": true, "sparse": true},
+//				{"key": ["expires"], "ttl": 3600}
+//			]
+//		}
+//	}
+// The indexes created from the declarations are named with a "chill_" prefix, only those are ever dropped, so indexes created
+// by hand are left alone.
+// A unique index violation comes back from the Sets as a field level "Already taken." error of the fields of the index.
+package indexes
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/errs"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/query"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const prefix = "chill_"
+
+// Field name of a key, without the order.
+func Field(key string) string {
+	return strings.TrimPrefix(key, "-")
+}
+
+func Fields(idx iface.Index) []string {
+	ret := []string{}
+	for _, v := range idx.Key {
+		ret = append(ret, Field(v))
+	}
+	return ret
+}
+
+// Name of a managed index, eg. "chill_author_1_created_-1".
+func Name(idx iface.Index) string {
+	parts := []string{}
+	for _, v := range idx.Key {
+		order := "1"
+		if strings.HasPrefix(v, "-") {
+			order = "-1"
+		}
+		parts = append(parts, Field(v) + "_" + order)
+	}
+	return prefix + strings.Join(parts, "_")
+}
+
+func Managed(name string) bool {
+	return strings.HasPrefix(name, prefix)
+}
+
+func Equal(a, b iface.Index) bool {
+	if a.Name != b.Name || a.Unique != b.Unique || a.Sparse != b.Sparse || a.TTL != b.TTL || len(a.Key) != len(b.Key) {
+		return false
+	}
+	for i := range a.Key {
+		if a.Key[i] != b.Key[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// The error to return when doc violates idx.
+func Taken(idx iface.Index) error {
+	return errs.AlreadyTaken(Fields(idx)...)
+}
+
+// Finds the index named name amongst idxs, and returns the error of its violation.
+// Falls back to a plain Conflict error if there is no such index.
+func TakenByName(idxs []iface.Index, name string) error {
+	for _, v := range idxs {
+		if v.Name == name {
+			return Taken(v)
+		}
+	}
+	return errs.New(errs.Conflict, "Duplicate key in index %v.", name)
+}
+
+// The values of the fields of idx in doc. Returns false if the document should be left out of the index because it is sparse.
+func Values(doc map[string]interface{}, idx iface.Index) ([]interface{}, bool) {
+	ret := []interface{}{}
+	found := false
+	for _, v := range Fields(idx) {
+		val, has := query.Get(doc, v)
+		if has {
+			found = true
+		}
+		ret = append(ret, val)
+	}
+	if idx.Sparse && !found {
+		return nil, false
+	}
+	return ret, true
+}
+
+// Reports if a and b have the same values for the fields of the unique index idx.
+func Collide(a, b map[string]interface{}, idx iface.Index) bool {
+	if !idx.Unique {
+		return false
+	}
+	av, ok := Values(a, idx)
+	if !ok {
+		return false
+	}
+	bv, ok := Values(b, idx)
+	if !ok {
+		return false
+	}
+	for i := range av {
+		if !query.Equal(av[i], bv[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports if doc expired according to the TTL index idx. The key field can hold a time.Time or a unix timestamp.
+// Used by the backends which have no TTL indexes on their own.
+func Expired(doc map[string]interface{}, idx iface.Index, now time.Time) bool {
+	if idx.TTL <= 0 || len(idx.Key) == 0 {
+		return false
+	}
+	v, _ := query.Get(doc, Field(idx.Key[0]))
+	var t time.Time
+	switch val := v.(type) {
+	case time.Time:
+		t = val
+	case int:
+		t = time.Unix(int64(val), 0)
+	case int64:
+		t = time.Unix(val, 0)
+	case float64:
+		t = time.Unix(int64(val), 0)
+	default:
+		return false
+	}
+	return !t.Add(time.Duration(idx.TTL) * time.Second).After(now)
+}
+
+// Parse creates an index from its declaration.
+func Parse(spec interface{}) (iface.Index, error) {
+	idx := iface.Index{}
+	m, ok := spec.(map[string]interface{})
+	if !ok {
+		return idx, fmt.Errorf("Index must be a map.")
+	}
+	keys, ok := m["key"].([]interface{})
+	if !ok || len(keys) == 0 {
+		return idx, fmt.Errorf("Key of an index must be a nonempty list of field names.")
+	}
+	for _, v := range keys {
+		k, ok := v.(string)
+		if !ok || Field(k) == "" {
+			return idx, fmt.Errorf("Key of an index must be a nonempty list of field names.")
+		}
+		idx.Key = append(idx.Key, k)
+	}
+	for i, v := range m {
+		switch i {
+		case "key":
+		case "unique":
+			idx.Unique, ok = v.(bool)
+		case "sparse":
+			idx.Sparse, ok = v.(bool)
+		case "ttl":
+			var f float64
+			f, ok = v.(float64)
+			if !ok {
+				var n int
+				n, ok = v.(int)
+				f = float64(n)
+			}
+			ok = ok && f > 0
+			idx.TTL = int(f)
+		default:
+			return idx, fmt.Errorf("Unkown index option %v.", i)
+		}
+		if !ok {
+			return idx, fmt.Errorf("Index option %v has a bad value.", i)
+		}
+	}
+	if idx.TTL > 0 && len(idx.Key) != 1 {
+		return idx, fmt.Errorf("TTL indexes must have exactly one field.")
+	}
+	idx.Name = Name(idx)
+	return idx, nil
+}
+
+// Indexes every site gets, regardless of the option document. Can be overridden by declaring an index with the same key.
+var Builtin = map[string][]iface.Index{
+	"users": []iface.Index{
+		{Name: prefix + "slug_1", Key: []string{"slug"}, Unique: true, Sparse: true},		// See user_model.NameAvailable.
+	},
+}
+
+// Declared collects the indexes declared in the option document, by collection.
+func Declared(opt map[string]interface{}) (map[string][]iface.Index, error) {
+	ret := map[string][]iface.Index{}
+	nouns, _ := opt["nouns"].(map[string]interface{})
+	for noun, v := range nouns {
+		nm, _ := v.(map[string]interface{})
+		specs, has := nm["indexes"]
+		if !has {
+			continue
+		}
+		sl, ok := specs.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Indexes of noun %v must be a list.", noun)
+		}
+		for i, spec := range sl {
+			idx, err := Parse(spec)
+			if err != nil {
+				return nil, fmt.Errorf("Index %v of noun %v: %v", i, noun, err)
+			}
+			ret[noun] = append(ret[noun], idx)
+		}
+	}
+	for coll, idxs := range Builtin {
+		for _, idx := range idxs {
+			if !named(ret[coll], idx.Name) {
+				ret[coll] = append(ret[coll], idx)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func named(idxs []iface.Index, name string) bool {
+	for _, v := range idxs {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Makes the managed indexes of one collection match the declared ones. Changed indexes are dropped and created again.
+func reconcileColl(ixr iface.Indexer, declared []iface.Index) error {
+	existing, err := ixr.Indexes()
+	if err != nil {
+		return err
+	}
+	ex := map[string]iface.Index{}
+	for _, v := range existing {
+		ex[v.Name] = v
+	}
+	decl := map[string]bool{}
+	for _, v := range declared {
+		decl[v.Name] = true
+	}
+	for _, v := range existing {
+		if !Managed(v.Name) {
+			continue
+		}
+		if !decl[v.Name] {
+			if err := ixr.DropIndex(v.Name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, v := range declared {
+		if old, has := ex[v.Name]; has {
+			if Equal(old, v) {
+				continue
+			}
+			if err := ixr.DropIndex(v.Name); err != nil {
+				return err
+			}
+		}
+		if err := ixr.EnsureIndex(v); err != nil {
+			return fmt.Errorf("Can't create index %v: %v", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// Reconcile makes the indexes of the given collections of st match the declared ones. A collection missing from declared
+// loses its managed indexes. Does nothing if the backend does not support indexes.
+func Reconcile(st iface.Storage, declared map[string][]iface.Index, colls []string) error {
+	for _, coll := range colls {
+		ixr, ok := st.Set(coll).(iface.Indexer)
+		if !ok {
+			return nil
+		}
+		if err := reconcileColl(ixr, declared[coll]); err != nil {
+			return fmt.Errorf("Collection %v: %v", coll, err)
+		}
+	}
+	return nil
+}
+
+// Reconciler runs Reconcile for the sites when their option document changes. It is safe for concurrent use.
+// The storage calls are made without holding any lock, so a slow index build of a site does not hold up the other sites.
+type Reconciler struct {
+	done	sync.Map						// site => The option document the indexes of the site were last reconciled with.
+	mut		sync.Mutex						// Guards the members below.
+	running	map[string]bool					// The sites being reconciled right now.
+	colls	map[string]map[string]bool		// The collections of a site having declared indexes the last time.
+}
+
+func NewReconciler() *Reconciler {
+	return &Reconciler{
+		running: map[string]bool{},
+		colls: map[string]map[string]bool{},
+	}
+}
+
+// Run reconciles the indexes of site, unless they were already reconciled with the very same option document, or
+// an other call is reconciling them right now. A failed run is retried by the next call.
+// Managed indexes of collections which lost all their declarations since the last successful run are dropped too, but
+// the ones declared before the start of the process are not known, those have to be dropped by hand.
+func (r *Reconciler) Run(st iface.Storage, site string, opt map[string]interface{}, opt_str string) error {
+	if doc, has := r.done.Load(site); has && doc.(string) == opt_str {
+		return nil
+	}
+	r.mut.Lock()
+	if r.running[site] {
+		r.mut.Unlock()
+		return nil
+	}
+	r.running[site] = true
+	prev := r.colls[site]
+	r.mut.Unlock()
+	cs, err := r.run(st, opt, prev)
+	r.mut.Lock()
+	delete(r.running, site)
+	if err == nil {
+		r.colls[site] = cs
+	}
+	r.mut.Unlock()
+	if err != nil {
+		return err
+	}
+	r.done.Store(site, opt_str)
+	return nil
+}
+
+// Reconciles the declared collections and the ones in prev, returns the declared ones.
+func (r *Reconciler) run(st iface.Storage, opt map[string]interface{}, prev map[string]bool) (map[string]bool, error) {
+	declared, err := Declared(opt)
+	if err != nil {
+		return nil, err
+	}
+	cs := map[string]bool{}
+	for coll := range declared {
+		cs[coll] = true
+	}
+	colls := []string{}
+	for coll := range prev {
+		if !cs[coll] {
+			colls = append(colls, coll)
+		}
+	}
+	for coll := range cs {
+		colls = append(colls, coll)
+	}
+	sort.Strings(colls)
+	return cs, Reconcile(st, declared, colls)
+}
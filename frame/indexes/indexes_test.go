@@ -0,0 +1,195 @@
+package indexes_test
+
+import(
+	"github.com/opesun/chill/frame/config"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/indexes"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/storage"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	idx, err := indexes.Parse(map[string]interface{}{"key": []interface{}{"author", "-created"}, "unique": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Name != "chill_author_1_created_-1" || !idx.Unique || idx.Sparse || len(idx.Key) != 2 {
+		t.Fatal(idx)
+	}
+	idx, err = indexes.Parse(map[string]interface{}{"key": []interface{}{"expires"}, "ttl": float64(60)})
+	if err != nil || idx.TTL != 60 {
+		t.Fatal(idx, err)
+	}
+	bad := []interface{}{
+		"slug",
+		map[string]interface{}{"key": []interface{}{}},
+		map[string]interface{}{"key": []interface{}{"-"}},
+		map[string]interface{}{"key": []interface{}{"a"}, "unique": "yes"},
+		map[string]interface{}{"key": []interface{}{"a"}, "ttl": -1},
+		map[string]interface{}{"key": []interface{}{"a", "b"}, "ttl": 60},
+		map[string]interface{}{"key": []interface{}{"a"}, "background": true},
+	}
+	for _, v := range bad {
+		if _, err := indexes.Parse(v); err == nil {
+			t.Fatal(v)
+		}
+	}
+}
+
+func TestDeclared(t *testing.T) {
+	opt := map[string]interface{}{
+		"nouns": map[string]interface{}{
+			"posts": map[string]interface{}{
+				"indexes": []interface{}{
+					map[string]interface{}{"key": []interface{}{"slug"}, "unique": true},
+				},
+			},
+			"comments": map[string]interface{}{},
+		},
+	}
+	decl, err := indexes.Declared(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decl["posts"]) != 1 || len(decl["comments"]) != 0 || len(decl["users"]) != len(indexes.Builtin["users"]) {
+		t.Fatal(decl)
+	}
+	opt["nouns"].(map[string]interface{})["posts"].(map[string]interface{})["indexes"] = "slug"
+	if _, err := indexes.Declared(opt); err == nil {
+		t.Fatal()
+	}
+}
+
+func TestTakenByName(t *testing.T) {
+	idxs := []iface.Index{{Name: "chill_make_1_model_1", Key: []string{"make", "model"}, Unique: true}}
+	f := errs.FieldsOf(indexes.TakenByName(idxs, "chill_make_1_model_1"))
+	if len(f) != 2 || f["make"] != "Already taken." {
+		t.Fatal(f)
+	}
+	err := indexes.TakenByName(idxs, "other")
+	if !errs.Is(err, errs.Conflict) || errs.FieldsOf(err) != nil {
+		t.Fatal(err)
+	}
+}
+
+func names(t *testing.T, st iface.Storage, coll string) map[string]iface.Index {
+	idxs, err := st.Set(coll).(iface.Indexer).Indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := map[string]iface.Index{}
+	for _, v := range idxs {
+		ret[v.Name] = v
+	}
+	return ret
+}
+
+func TestReconciler(t *testing.T) {
+	conn, err := storage.Open(&config.Config{DBBackend: "memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sess, err := conn.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	st := sess.Storage()
+	// Indexes created by hand are left alone.
+	err = st.Set("posts").(iface.Indexer).EnsureIndex(iface.Index{Name: "by_hand", Key: []string{"title"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := map[string]interface{}{
+		"nouns": map[string]interface{}{
+			"posts": map[string]interface{}{
+				"indexes": []interface{}{
+					map[string]interface{}{"key": []interface{}{"slug"}, "unique": true},
+					map[string]interface{}{"key": []interface{}{"author", "-created"}},
+				},
+			},
+		},
+	}
+	r := indexes.NewReconciler()
+	err = r.Run(st, "", opt, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := names(t, st, "posts")
+	if len(posts) != 3 || !posts["chill_slug_1"].Unique {
+		t.Fatal(posts)
+	}
+	if _, has := names(t, st, "users")["chill_slug_1"]; !has {
+		t.Fatal()
+	}
+	err = st.Set("posts").Insert(map[string]interface{}{"slug": "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Set("posts").Insert(map[string]interface{}{"slug": "hello"})
+	if errs.FieldsOf(err)["slug"] != "Already taken." {
+		t.Fatal(err)
+	}
+	// Changing a declaration recreates the index, removing one drops it.
+	opt["nouns"] = map[string]interface{}{
+		"posts": map[string]interface{}{
+			"indexes": []interface{}{
+				map[string]interface{}{"key": []interface{}{"slug"}, "unique": true, "sparse": true},
+			},
+		},
+	}
+	err = r.Run(st, "", opt, "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts = names(t, st, "posts")
+	if len(posts) != 2 || !posts["chill_slug_1"].Sparse {
+		t.Fatal(posts)
+	}
+	// The same document is not reconciled again.
+	err = st.Set("posts").(iface.Indexer).DropIndex("chill_slug_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Run(st, "", opt, "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, has := names(t, st, "posts")["chill_slug_1"]; has {
+		t.Fatal()
+	}
+	// A failed run is retried with the same document.
+	opt["nouns"] = map[string]interface{}{
+		"posts": map[string]interface{}{
+			"indexes": []interface{}{
+				map[string]interface{}{"key": []interface{}{"title"}, "unique": true},
+			},
+		},
+	}
+	st.Set("posts").Insert(map[string]interface{}{"title": "Same."})
+	st.Set("posts").Insert(map[string]interface{}{"title": "Same."})
+	if err := r.Run(st, "", opt, "v3"); err == nil {
+		t.Fatal()
+	}
+	if _, err := st.Set("posts").RemoveAll(map[string]interface{}{"title": "Same."}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(st, "", opt, "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, has := names(t, st, "posts")["chill_title_1"]; !has {
+		t.Fatal(names(t, st, "posts"))
+	}
+	// Dropping the noun drops its managed indexes.
+	opt["nouns"] = map[string]interface{}{}
+	err = r.Run(st, "", opt, "v4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts = names(t, st, "posts")
+	if len(posts) != 1 {
+		t.Fatal(posts)
+	}
+}
@@ -37,9 +37,12 @@ type Set interface {
 	Skip(int)
 	Limit(int)
 	Sort(...string)
+	Select(map[string]int)
 	Count(map[string]interface{}) (int, error)
 	FindOne(map[string]interface{}) (map[string]interface{}, error)
 	Find(map[string]interface{}) ([]interface{}, error)
+	Iter(q map[string]interface{}, batch int) (Cursor, error)
+	Aggregate(q map[string]interface{}, agg Aggregation) ([]map[string]interface{}, error)
 	Insert(map[string]interface{}) error
 	// InsertAll([]map[string]interface{}) errors
 	Update(map[string]interface{}, map[string]interface{}) error
@@ -49,16 +52,79 @@ type Set interface {
 	Name()	string
 }
 
+// Streams the results of a query one by one instead of loading them at once, see Set.Iter.
+// Next returns false when the results are exhausted or an error happened, Err tells which one.
+// Close must be called when done with the cursor.
+type Cursor interface {
+	Next() (map[string]interface{}, bool)
+	Err() error
+	Close() error
+}
+
+// An aggregation over the documents matching a query, see Set.Aggregate. The documents are grouped by the values of the
+// By fields (all of them form a single group if By is empty), and the Ops are computed for every group.
+type Aggregation struct {
+	By		[]GroupBy
+	Ops		[]AggOp
+}
+
+type GroupBy struct {
+	Field	string
+	Bucket	string		// Groups timestamps by "hour", "day", "month" or "year" instead of their exact value.
+}
+
+type AggOp struct {
+	Op		string		// One of count, sum, avg, min, max and distinct.
+	Field	string		// Not used by count.
+}
+
+// Options of Filter.Iterate.
+type IterOptions struct {
+	Batch		int					// Number of documents fetched at once, 0 means the default.
+	Progress	func(done, total int)	// Called after every batch.
+	Cancel		<-chan struct{}		// Closing it stops the iteration before the next document.
+}
+
+// An index of a Set. Key lists the fields, the ones prefixed with "-" are in descending order.
+type Index struct {
+	Name	string
+	Key		[]string
+	Unique	bool
+	Sparse	bool		// Documents missing all the fields of the key are left out of the index.
+	TTL		int			// Documents expire this many seconds after the time stored in the key field, 0 means never.
+}
+
+// Sets of the backends supporting indexes implement this too, see package indexes.
+type Indexer interface {
+	Indexes() ([]Index, error)
+	EnsureIndex(Index) error
+	DropIndex(name string) error
+}
+
+// The database of a site. Produces the Sets of the collections and the store of the option documents.
+type Storage interface {
+	Set(string) Set
+	Options() OptionStore
+}
+
+type OptionStore interface {
+	// Returns the freshest option document, nil if there is none yet.
+	Latest() (map[string]interface{}, error)
+	Insert(map[string]interface{}) error
+}
+
 type Filter interface {
 	Ids() ([]bson.ObjectId, error)
 	AddQuery(map[string]interface{}) Filter
 	Clone() Filter
 	Reduce(...Filter) (Filter, error)
 	Subject() string
-	AddParents(string, []bson.ObjectId)
+	AddParents(string, []bson.ObjectId) Filter
 	Modifiers() Modifiers
+	Cursors() (string, string)
 	Count()	(int, error)
-	Iterate(func(map[string]interface{}, Grabbed) error) error
+	Iterate(*IterOptions, func(map[string]interface{}, Grabbed) error) error
+	Aggregate(Aggregation) ([]map[string]interface{}, error)
 	// --
 	FindOne() (map[string]interface{}, error)
 	Find() ([]interface{}, error)
@@ -79,4 +145,5 @@ type Modifiers interface {
 	Sort()		[]string
 	Limit()		int
 	Skip()		int
+	Fields()	[]string
 }
\ No newline at end of file
@@ -5,6 +5,8 @@ import(
 	"sort"
 	"fmt"
 	"encoding/base64"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/fieldtypes"
 )
 
 // Cleans all bson.M s to map[string]interface{} s. Usually called on db query results.
@@ -44,15 +46,25 @@ func Mapify(a map[string][]string) map[string]interface{} {
 	return ret
 }
 
+// The "input" of the item is the HTML input type matching the field type, see package fieldtypes.
 func createItem(key string, scheme interface{}, dat interface{}) map[string]interface{} {
 	item := map[string]interface{}{"key": key}
 	item["value"] = dat
+	item["input"] = "text"
 	if sch, ok := scheme.(map[string]interface{}); ok {
 		if typ, hast := sch["type"]; hast {
 			item["type"] = typ
+			typs, _ := typ.(string)
+			item["input"] = fieldtypes.Input(typs)
+			item["value"] = fieldtypes.FormValue(typs, dat)
 		}
-		if disp, hasd := sch["disp"]; hasd {
-			item["disp"] = disp
+		for _, v := range []string{"disp", "min", "max", "pattern", "required"} {
+			if val, has := sch[v]; has {
+				item[v] = val
+			}
+		}
+		if values, hasv := sch["values"]; hasv {
+			item["options"] = values
 		}
 	}
 	return item
@@ -101,6 +113,39 @@ func SchemeToFields(scheme interface{}, dat interface{}) ([]map[string]interface
 	return abcKeys(rm, datm, []string{"title", "name", "slug"}), nil
 }
 
+func firstString(a interface{}) (string, bool) {
+	switch v := a.(type) {
+	case string:
+		return v, true
+	case []string:
+		if len(v) > 0 {
+			return v[0], true
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			s, ok := v[0].(string)
+			return s, ok
+		}
+	}
+	return "", false
+}
+
+// Fills the form fields created by SchemeToFields with the result of a failed submission.
+// After a failed validation the redirect url contains the messages as "-err.<field>" and the submitted values as "-val.<field>",
+// mods is the modifier map those end up in. The message of a field is put under the key "error", the submitted value overrides "value".
+func WithFeedback(fields []map[string]interface{}, mods map[string]interface{}) []map[string]interface{} {
+	for _, v := range fields {
+		key, _ := v["key"].(string)
+		if msg, ok := firstString(mods["err." + key]); ok {
+			v["error"] = msg
+		}
+		if val, ok := firstString(mods["val." + key]); ok {
+			v["value"] = val
+		}
+	}
+	return fields
+}
+
 // A more generic version of abcKeys. Takes a map[string]interface{} and puts every element of that into an []interface{}, ordered by keys alphabetically.
 // TODO: find the intersecting parts between the two functions and refactor.
 func OrderKeys(d map[string]interface{}) []interface{} {
@@ -153,8 +198,8 @@ func IdsToStrings(v interface{}) {
 
 func DecodeId(s string) (bson.ObjectId, error) {
 	val, err := base64.URLEncoding.DecodeString(s)
-	if err != nil {
-		panic("Can't decode id: "+ err.Error())
+	if err != nil || len(val) != 12 {
+		return "", errs.New(errs.BadInput, "Can't decode id %v.", s)
 	}
 	return bson.ObjectId(val), nil
 }
@@ -4,6 +4,7 @@ package scut
 
 import (
 	"fmt"
+	"github.com/opesun/chill/frame/errs"
 	"github.com/opesun/numcon"
 	"io/ioutil"
 	"path/filepath"
@@ -134,19 +135,26 @@ func Merge(a map[string]interface{}, b map[string]interface{}) {
 
 // CanonicalHost(uni.Req.Host, uni.Opt)
 // Gives you back the canonical address of the site so it can be made available from different domains.
-func Host(host string, opt map[string]interface{}) string {
+func Host(host string, opt map[string]interface{}) (string, error) {
 	alias_whitelist, has_alias_whitelist := opt["host_alias_whitelist"]
 	if has_alias_whitelist {
-		awm := alias_whitelist.(map[string]interface{})
+		awm, ok := alias_whitelist.(map[string]interface{})
+		if !ok {
+			return "", errs.New(errs.Internal, "Option host_alias_whitelist is not a map.")
+		}
 		if _, allowed := awm[host]; !allowed && len(awm) > 0 { // To prevent entirely locking yourself out of the site. Still can introduce problems if misused.
-			panic(fmt.Sprintf("Unapproved host alias %v.", host))
+			return "", errs.New(errs.Forbidden, "Unapproved host alias %v.", host)
 		}
 	}
 	canon_host, has_canon := opt["canonical_host"]
 	if !has_canon {
-		return host
+		return host, nil
+	}
+	canon, ok := canon_host.(string)
+	if !ok {
+		return "", errs.New(errs.Internal, "Option canonical_host is not a string.")
 	}
-	return canon_host.(string)
+	return canon, nil
 }
 
 func OnlyAdmin(dat map[string]interface{}) {
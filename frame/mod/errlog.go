@@ -0,0 +1,7 @@
+package mod
+
+import "github.com/opesun/chill/modules/errlog"
+
+func init() {
+	mods.register("errlog", errlog.C{})
+}
\ No newline at end of file
@@ -0,0 +1,7 @@
+package mod
+
+import "github.com/opesun/chill/modules/sites"
+
+func init() {
+	mods.register("sites", sites.C{})
+}
\ No newline at end of file
@@ -0,0 +1,123 @@
+// Package options takes care of loading and caching the option documents.
+package options
+
+import(
+	"encoding/json"
+	"fmt"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/query"
+	"sync"
+	"time"
+)
+
+const (
+	cant_encode_config = "Can't encode config. - No way this should happen anyway."
+	cant_unmarshal     = "Can't unmarshal freshly encoded option document."
+)
+
+type entry struct {
+	doc		map[string]interface{}
+	str		string
+}
+
+// Cache holds the parsed option documents per site. It is safe for concurrent use.
+// Every Get returns a deep copy, so one pageload can't mess up the option document for the next.
+type Cache struct {
+	mut		sync.RWMutex
+	m		map[string]entry
+	gen		int		// Incremented on every invalidation, so a load started before one can't put a stale document into the cache.
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		m: map[string]entry{},
+	}
+}
+
+// Returns a copy of the parsed option document and its JSON encoded version.
+func (c *Cache) Get(host string) (map[string]interface{}, string, bool) {
+	c.mut.RLock()
+	e, has := c.m[host]
+	c.mut.RUnlock()
+	if !has {
+		return nil, "", false
+	}
+	return query.CopyMap(e.doc), e.str, true
+}
+
+func (c *Cache) Set(host string, doc map[string]interface{}, str string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.m[host] = entry{query.CopyMap(doc), str}
+}
+
+func (c *Cache) generation() int {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.gen
+}
+
+// Sets only if there were no invalidations since gen.
+func (c *Cache) setIfFresh(gen int, host string, doc map[string]interface{}, str string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.gen != gen {
+		return
+	}
+	c.m[host] = entry{query.CopyMap(doc), str}
+}
+
+func (c *Cache) Invalidate(host string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.gen++
+	delete(c.m, host)
+}
+
+func (c *Cache) InvalidateAll() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.gen++
+	c.m = map[string]entry{}
+}
+
+// Loads the freshest option document from the store, inserts an empty one if there is none yet.
+// Returns both the map[string]interface{} and a JSON encoded string version too.
+// The string version is being returned to be able to serve a version of the option document which is 100% untampered.
+// The map is decoded from the string, so it holds the same JSON types whether it comes from the store or from the cache.
+func Load(opts iface.OptionStore) (map[string]interface{}, string, error) {
+	latest, err := opts.Latest()
+	if err != nil {
+		return nil, "", err
+	}
+	if latest == nil {
+		latest = map[string]interface{}{}
+		opts.Insert(map[string]interface{}{"created": time.Now().UnixNano()})		// Intentionally skipping error here.
+	}
+	enc, err := json.Marshal(latest)
+	if err != nil {
+		return nil, "", fmt.Errorf(cant_encode_config)
+	}
+	var v interface{}
+	json.Unmarshal(enc, &v)
+	ret, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf(cant_unmarshal)
+	}
+	delete(ret, "_id")
+	return ret, string(enc), nil
+}
+
+// Same as Load, but serves from the cache if possible.
+func (c *Cache) Load(opts iface.OptionStore, host string) (map[string]interface{}, string, error) {
+	if doc, str, has := c.Get(host); has {
+		return doc, str, nil
+	}
+	gen := c.generation()
+	doc, str, err := Load(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	c.setIfFresh(gen, host, doc, str)
+	return doc, str, nil
+}
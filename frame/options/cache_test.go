@@ -0,0 +1,58 @@
+package options_test
+
+import(
+	"github.com/opesun/chill/frame/options"
+	"github.com/opesun/chill/frame/set/memset"
+	"github.com/opesun/chill/frame/storage"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	set := memset.New(memset.NewDb(), "options")
+	opts := storage.NewOptionStore(set)
+	c := options.NewCache()
+	doc, _, err := c.Load(opts, "example.com")
+	if err != nil || len(doc) != 0 {
+		t.Fatal(doc, err)
+	}
+	set.Insert(map[string]interface{}{"created": time.Now().UnixNano() + 1, "title": "new"})
+	doc, _, _ = c.Load(opts, "example.com")
+	if doc["title"] != nil {
+		t.Fatal(doc)
+	}
+	doc["title"] = "tampered"
+	doc, _, _ = c.Load(opts, "example.com")
+	if doc["title"] != nil {
+		t.Fatal(doc)
+	}
+	c.InvalidateAll()
+	doc, str, _ := c.Load(opts, "example.com")
+	if doc["title"] != "new" || str == "" {
+		t.Fatal(doc, str)
+	}
+}
+
+func TestConcurrentLoad(t *testing.T) {
+	set := memset.New(memset.NewDb(), "options")
+	opts := storage.NewOptionStore(set)
+	c := options.NewCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%5 == 0 {
+				c.InvalidateAll()
+			}
+			doc, _, err := c.Load(opts, "example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			doc["x"] = i
+		}(i)
+	}
+	wg.Wait()
+}
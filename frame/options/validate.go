@@ -0,0 +1,433 @@
+package options
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/computed"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/event"
+	"github.com/opesun/chill/frame/filter"
+	"github.com/opesun/chill/frame/indexes"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/numcon"
+	"github.com/opesun/sanitize"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A problem found in the option document. Field is the dotted path of the offending member, eg. "nouns.cars.composed_of.1".
+type FieldError struct {
+	Field	string
+	Msg		string
+}
+
+func (f FieldError) Error() string {
+	return f.Field + ": " + f.Msg
+}
+
+// All problems found in an option document.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	strs := []string{}
+	for _, v := range e {
+		strs = append(strs, v.Error())
+	}
+	return strings.Join(strs, " ")
+}
+
+func (e Errors) Kind() errs.Kind {
+	return errs.Validation
+}
+
+func (e Errors) Fields() map[string]string {
+	ret := map[string]string{}
+	for _, v := range e {
+		if _, has := ret[v.Field]; !has {
+			ret[v.Field] = v.Msg
+		}
+	}
+	return ret
+}
+
+type validator struct {
+	newModule	func(string) iface.Module
+	errs		Errors
+}
+
+func (v *validator) add(field, msg string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{field, fmt.Sprintf(msg, args...)})
+}
+
+func join(path string, key interface{}) string {
+	return fmt.Sprintf("%v.%v", path, key)
+}
+
+// Iterates a map in a fixed order, so the errors come out the same way every time.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for i := range m {
+		keys = append(keys, i)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *validator) module(field string, modname interface{}) (iface.Instance, bool) {
+	name, ok := modname.(string)
+	if !ok {
+		v.add(field, "Module name must be a string.")
+		return nil, false
+	}
+	mo := v.newModule(name)
+	if !mo.Exists() {
+		v.add(field, "Module %v does not exist.", name)
+		return nil, false
+	}
+	return mo.Instance(), true
+}
+
+func (v *validator) level(field string, lev interface{}) {
+	if _, err := numcon.Int(lev); err != nil {
+		v.add(field, "Level must be a number.")
+	}
+}
+
+var http_methods = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+func (v *validator) methods(field string, methods interface{}) {
+	ms, ok := methods.([]interface{})
+	if !ok {
+		v.add(field, "Must be a list of HTTP methods.")
+		return
+	}
+	for i, x := range ms {
+		m, _ := x.(string)
+		if !http_methods[strings.ToUpper(m)] {
+			v.add(join(field, i), "Unkown HTTP method %v.", x)
+		}
+	}
+}
+
+// Checks the options of the field types, see package fieldtypes.
+func (v *validator) input(field string, scheme map[string]interface{}) {
+	for _, key := range sortedKeys(scheme) {
+		sch, ok := scheme[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		kfield := join(field, key)
+		switch typ, _ := sch["type"].(string); typ {
+		case "enum":
+			if values, ok := sch["values"].([]interface{}); !ok || len(values) == 0 {
+				v.add(join(kfield, "values"), "Enum fields need a nonempty list of allowed values.")
+			}
+		case "ref":
+			if noun, _ := sch["noun"].(string); noun == "" {
+				v.add(join(kfield, "noun"), "Reference fields must name the noun they refer to.")
+			}
+		}
+		for _, lim := range []string{"min", "max"} {
+			if l, has := sch[lim]; has {
+				if _, err := numcon.Float64(l); err != nil {
+					v.add(join(kfield, lim), "Must be a number.")
+				}
+			}
+		}
+		if pat, has := sch["pattern"]; has {
+			if ps, ok := pat.(string); !ok {
+				v.add(join(kfield, "pattern"), "Must be a string.")
+			} else if _, err := regexp.Compile(ps); err != nil {
+				v.add(join(kfield, "pattern"), "Pattern does not compile: %v", err)
+			}
+		}
+	}
+}
+
+func (v *validator) verbs(field string, verbs interface{}, modules []iface.Instance) {
+	vm, ok := verbs.(map[string]interface{})
+	if !ok {
+		v.add(field, "Must be a map.")
+		return
+	}
+	for _, verb := range sortedKeys(vm) {
+		vfield := join(field, verb)
+		has := false
+		for _, ins := range modules {
+			if ins.HasMethod(verb) {
+				has = true
+				break
+			}
+		}
+		if !has && len(modules) > 0 {
+			v.add(vfield, "None of the modules the noun is composed of has a verb named %v.", verb)
+		}
+		opts, ok := vm[verb].(map[string]interface{})
+		if !ok {
+			v.add(vfield, "Must be a map.")
+			continue
+		}
+		if input, has := opts["input"]; has {
+			scheme, ok := input.(map[string]interface{})
+			if !ok {
+				v.add(join(vfield, "input"), "Sanitization scheme must be a map.")
+			} else if _, err := sanitize.New(scheme); err != nil {
+				v.add(join(vfield, "input"), "Sanitization scheme does not compile: %v", err)
+			} else {
+				v.input(join(vfield, "input"), scheme)
+			}
+		}
+		if comp, has := opts["computed"]; has {
+			if cm, ok := comp.(map[string]interface{}); !ok {
+				v.add(join(vfield, "computed"), "Must be a map.")
+			} else {
+				for _, k := range sortedKeys(cm) {
+					if err := computed.Check(cm[k]); err != nil {
+						v.add(join(join(vfield, "computed"), k), "%v", err)
+					}
+				}
+			}
+		}
+		if lev, has := opts["level"]; has {
+			v.level(join(vfield, "level"), lev)
+		}
+		if methods, has := opts["methods"]; has {
+			v.methods(join(vfield, "methods"), methods)
+		}
+		if safe, has := opts["safe"]; has {
+			if _, ok := safe.(bool); !ok {
+				v.add(join(vfield, "safe"), "Must be true or false.")
+			}
+		}
+	}
+}
+
+func (v *validator) nouns(nouns interface{}) {
+	nm, ok := nouns.(map[string]interface{})
+	if !ok {
+		v.add("nouns", "Must be a map.")
+		return
+	}
+	for _, noun := range sortedKeys(nm) {
+		field := join("nouns", noun)
+		opts, ok := nm[noun].(map[string]interface{})
+		if !ok {
+			v.add(field, "Must be a map.")
+			continue
+		}
+		comp, ok := opts["composed_of"].([]interface{})
+		if !ok || len(comp) == 0 {
+			v.add(join(field, "composed_of"), "Must be a nonempty list of module names.")
+		}
+		modules := []iface.Instance{}
+		for i, modname := range comp {
+			if ins, ok := v.module(join(join(field, "composed_of"), i), modname); ok {
+				modules = append(modules, ins)
+			}
+		}
+		if len(modules) != len(comp) {
+			modules = nil		// Can't tell which verbs exist if some of the modules are missing.
+		}
+		if verbs, has := opts["verbs"]; has {
+			v.verbs(join(field, "verbs"), verbs, modules)
+		}
+		if idxs, has := opts["indexes"]; has {
+			v.indexes(join(field, "indexes"), idxs)
+		}
+		if filters, has := opts["filters"]; has {
+			v.filters(join(field, "filters"), filters)
+		}
+		if rels, has := opts["relations"]; has {
+			v.relations(join(field, "relations"), rels, nm)
+		}
+		v.fields(field, opts)
+	}
+}
+
+// Checks default_fields and forbidden_fields, see filter.Filter.Project.
+func (v *validator) fields(field string, opts map[string]interface{}) {
+	lists := map[string]map[string]bool{}
+	for _, k := range []string{"default_fields", "forbidden_fields"} {
+		l, has := opts[k]
+		if !has {
+			continue
+		}
+		sl, ok := l.([]interface{})
+		if !ok {
+			v.add(join(field, k), "Must be a list of field names.")
+			continue
+		}
+		lists[k] = map[string]bool{}
+		for i, x := range sl {
+			s, ok := x.(string)
+			if !ok || s == "" || strings.HasPrefix(s, "-") {
+				v.add(join(join(field, k), i), "Must be a field name.")
+				continue
+			}
+			lists[k][s] = true
+		}
+	}
+	for i := range lists["default_fields"] {
+		if lists["forbidden_fields"][i] {
+			v.add(join(field, "default_fields"), "Field %v is forbidden.", i)
+		}
+	}
+}
+
+// See filter.Allowed.
+func (v *validator) filters(field string, filters interface{}) {
+	fm, ok := filters.(map[string]interface{})
+	if !ok {
+		v.add(field, "Must be a map.")
+		return
+	}
+	for _, k := range sortedKeys(fm) {
+		typ, _ := fm[k].(string)
+		known := false
+		for _, t := range filter.Types {
+			if t == typ {
+				known = true
+			}
+		}
+		if !known {
+			v.add(join(field, k), "Type must be one of %v.", strings.Join(filter.Types, ", "))
+		}
+	}
+}
+
+// See filter.Relations.
+func (v *validator) relations(field string, rels interface{}, nouns map[string]interface{}) {
+	rm, ok := rels.(map[string]interface{})
+	if !ok {
+		v.add(field, "Must be a map.")
+		return
+	}
+	for _, k := range sortedKeys(rm) {
+		to, _ := rm[k].(string)
+		if _, has := nouns[to]; !has {
+			v.add(join(field, k), "Must be the name of a noun.")
+		}
+	}
+}
+
+// See package indexes for the format of the declarations.
+func (v *validator) indexes(field string, idxs interface{}) {
+	sl, ok := idxs.([]interface{})
+	if !ok {
+		v.add(field, "Must be a list.")
+		return
+	}
+	names := map[string]bool{}
+	for i, spec := range sl {
+		idx, err := indexes.Parse(spec)
+		if err != nil {
+			v.add(join(field, i), "%v", err)
+			continue
+		}
+		if names[idx.Name] {
+			v.add(join(field, i), "Index with the same key is already declared.")
+		}
+		names[idx.Name] = true
+	}
+}
+
+// See event.all for the format of the subscriptions.
+func (v *validator) hooks(field, eventname string, hooks interface{}) {
+	switch h := hooks.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(h) {
+			en := k
+			if eventname != "" {
+				en = eventname + "." + k
+			}
+			v.hooks(join(field, k), en, h[k])
+		}
+	case []interface{}:
+		for i, sub := range h {
+			sfield := join(field, i)
+			modname := sub
+			method := event.Hooknameize(eventname)
+			if pair, is_pair := sub.([]interface{}); is_pair {
+				if len(pair) != 2 {
+					v.add(sfield, "Misconfigured hook, must be a module name or a [module name, method name] pair.")
+					continue
+				}
+				modname = pair[0]
+				method, _ = pair[1].(string)
+				if method == "" {
+					v.add(join(sfield, 1), "Method name must be a nonempty string.")
+					continue
+				}
+			}
+			ins, ok := v.module(sfield, modname)
+			if ok && !ins.HasMethod(method) {
+				v.add(sfield, "Module %v has no method named %v.", modname, method)
+			}
+		}
+	default:
+		v.add(field, "Must be a map or a list of subscriptions.")
+	}
+}
+
+func (v *validator) loads(field string, loads interface{}) {
+	switch l := loads.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(l) {
+			v.loads(join(field, k), l[k])
+		}
+	case []interface{}:
+		for i, x := range l {
+			if _, ok := x.(string); !ok {
+				v.add(join(field, i), "Must be a file path.")
+			}
+		}
+	default:
+		v.add(field, "Must be a map or a list of file paths.")
+	}
+}
+
+// Validate checks the option document before it is saved.
+// newModule is used to look up the modules referenced from the document, and the methods the hooks refer to.
+// Returns nil if everything is all right.
+func Validate(opt map[string]interface{}, newModule func(string) iface.Module) error {
+	v := &validator{newModule: newModule}
+	if nouns, has := opt["nouns"]; has {
+		v.nouns(nouns)
+	}
+	if hooks, has := opt["Hooks"]; has {
+		if _, ok := hooks.(map[string]interface{}); !ok {
+			v.add("Hooks", "Must be a map.")
+		} else {
+			v.hooks("Hooks", "", hooks)
+		}
+	}
+	if loads, has := opt["Loads"]; has {
+		if _, ok := loads.(map[string]interface{}); !ok {
+			v.add("Loads", "Must be a map.")
+		} else {
+			v.loads("Loads", loads)
+		}
+	}
+	if tpl, has := opt["Template"]; has {
+		if s, ok := tpl.(string); !ok || s == "" || strings.ContainsAny(s, "/\\.") {
+			v.add("Template", "Must be the name of a template directory.")
+		}
+	}
+	if whitelist, has := opt["host_alias_whitelist"]; has {
+		if _, ok := whitelist.(map[string]interface{}); !ok {
+			v.add("host_alias_whitelist", "Must be a map with the allowed hosts as keys.")
+		}
+	}
+	if canon, has := opt["canonical_host"]; has {
+		if _, ok := canon.(string); !ok {
+			v.add("canonical_host", "Must be a string.")
+		}
+	}
+	if lev, has := opt["default_level"]; has {
+		v.level("default_level", lev)
+	}
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
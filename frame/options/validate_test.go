@@ -0,0 +1,251 @@
+package options_test
+
+import(
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/mod"
+	"github.com/opesun/chill/frame/options"
+	"testing"
+)
+
+type M struct {
+	name	string
+}
+
+func (m *M) Instance() iface.Instance {
+	return mod.ToInstance(&Cars{})
+}
+
+func (m *M) Exists() bool {
+	return m.name == "cars"
+}
+
+type Cars struct{}
+
+func (c *Cars) Insert() {
+}
+
+func (c *Cars) ContentInsert() {
+}
+
+func newModule(s string) iface.Module {
+	return &M{s}
+}
+
+func fields(err error) map[string]bool {
+	ret := map[string]bool{}
+	if err == nil {
+		return ret
+	}
+	for _, v := range err.(options.Errors) {
+		ret[v.Field] = true
+	}
+	return ret
+}
+
+func TestValid(t *testing.T) {
+	opt := map[string]interface{}{
+		"nouns": map[string]interface{}{
+			"cars": map[string]interface{}{
+				"composed_of": []interface{}{"cars"},
+				"verbs": map[string]interface{}{
+					"Insert": map[string]interface{}{
+						"input": map[string]interface{}{
+							"make": 1,
+						},
+						"level": 100,
+						"methods": []interface{}{"post", "PUT"},
+						"safe": false,
+					},
+				},
+			},
+		},
+		"Hooks": map[string]interface{}{
+			"content": map[string]interface{}{
+				"insert": []interface{}{"cars", []interface{}{"cars", "Insert"}},
+			},
+		},
+		"Loads": map[string]interface{}{
+			"head": []interface{}{"a.t", "b.t"},
+		},
+		"Template": "default",
+		"default_level": 0,
+	}
+	err := options.Validate(opt, newModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	opt := map[string]interface{}{
+		"nouns": map[string]interface{}{
+			"cars": map[string]interface{}{
+				"composed_of": []interface{}{"cars"},
+				"verbs": map[string]interface{}{
+					"Fly": map[string]interface{}{
+						"input": map[string]interface{}{
+							"make": "what",
+						},
+						"level": "high",
+						"methods": []interface{}{"POST", "FETCH"},
+						"safe": "yes",
+					},
+				},
+			},
+			"boats": map[string]interface{}{
+				"composed_of": []interface{}{"boats"},
+			},
+		},
+		"Hooks": map[string]interface{}{
+			"Inserted": []interface{}{"cars", []interface{}{"cars"}},
+		},
+		"Loads": map[string]interface{}{
+			"head": "a.t",
+		},
+		"Template": "../etc",
+		"host_alias_whitelist": []interface{}{"example.com"},
+	}
+	f := fields(options.Validate(opt, newModule))
+	expected := []string{
+		"nouns.cars.verbs.Fly",
+		"nouns.cars.verbs.Fly.input",
+		"nouns.cars.verbs.Fly.level",
+		"nouns.cars.verbs.Fly.methods.1",
+		"nouns.cars.verbs.Fly.safe",
+		"nouns.boats.composed_of.0",
+		"Hooks.Inserted.0",
+		"Hooks.Inserted.1",
+		"Loads.head",
+		"Template",
+		"host_alias_whitelist",
+	}
+	for _, v := range expected {
+		if !f[v] {
+			t.Fatal(v, f)
+		}
+	}
+	if len(f) != len(expected) {
+		t.Fatal(f)
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	opt := map[string]interface{}{
+		"nouns": map[string]interface{}{
+			"cars": map[string]interface{}{
+				"composed_of": []interface{}{"cars"},
+				"verbs": map[string]interface{}{
+					"Insert": map[string]interface{}{
+						"input": map[string]interface{}{
+							"color": map[string]interface{}{"type": "enum"},
+							"doors": map[string]interface{}{"type": "integer", "min": "few", "max": 5},
+							"plate": map[string]interface{}{"type": "text", "pattern": "[A-Z"},
+							"make": map[string]interface{}{"type": "text", "pattern": "[A-Z]+"},
+							"owner": map[string]interface{}{"type": "ref"},
+							"dealer": map[string]interface{}{"type": "ref", "noun": "dealers"},
+						},
+						"computed": map[string]interface{}{
+							"created_at": "now",
+							"created_by": "yesterday",
+							"slug": map[string]interface{}{"slug": "make"},
+							"status": map[string]interface{}{"value": "new", "default": "old"},
+						},
+					},
+				},
+			},
+		},
+	}
+	f := fields(options.Validate(opt, newModule))
+	expected := []string{
+		"nouns.cars.verbs.Insert.input.color.values",
+		"nouns.cars.verbs.Insert.input.doors.min",
+		"nouns.cars.verbs.Insert.input.plate.pattern",
+		"nouns.cars.verbs.Insert.input.owner.noun",
+		"nouns.cars.verbs.Insert.computed.created_by",
+		"nouns.cars.verbs.Insert.computed.status",
+	}
+	for _, v := range expected {
+		if !f[v] {
+			t.Fatal(v, f)
+		}
+	}
+	if len(f) != len(expected) {
+		t.Fatal(f)
+	}
+}
+
+func TestInvalidIndexes(t *testing.T) {
+	opt := map[string]interface{}{
+		"nouns": map[string]interface{}{
+			"cars": map[string]interface{}{
+				"composed_of": []interface{}{"cars"},
+				"indexes": []interface{}{
+					map[string]interface{}{"key": []interface{}{"plate"}, "unique": true},
+					map[string]interface{}{"key": []interface{}{}},
+					map[string]interface{}{"key": []interface{}{"make", "model"}, "ttl": 60},
+					map[string]interface{}{"key": []interface{}{"plate"}},
+					map[string]interface{}{"key": []interface{}{"color"}, "clustered": true},
+				},
+			},
+			"boats": map[string]interface{}{
+				"composed_of": []interface{}{"cars"},
+				"indexes": map[string]interface{}{},
+				"filters": map[string]interface{}{"length": "number", "name": "text"},
+				"default_fields": []interface{}{"name", "owner"},
+				"forbidden_fields": []interface{}{"owner", "-price"},
+			},
+		},
+	}
+	f := fields(options.Validate(opt, newModule))
+	expected := []string{
+		"nouns.cars.indexes.1",
+		"nouns.cars.indexes.2",
+		"nouns.cars.indexes.3",
+		"nouns.cars.indexes.4",
+		"nouns.boats.indexes",
+		"nouns.boats.filters.name",
+		"nouns.boats.default_fields",
+		"nouns.boats.forbidden_fields.1",
+	}
+	for _, v := range expected {
+		if !f[v] {
+			t.Fatal(v, f)
+		}
+	}
+	if len(f) != len(expected) {
+		t.Fatal(f)
+	}
+}
+
+func TestInvalidRelations(t *testing.T) {
+	opt := map[string]interface{}{
+		"nouns": map[string]interface{}{
+			"cars": map[string]interface{}{
+				"composed_of": []interface{}{"cars"},
+				"relations": map[string]interface{}{
+					"owner_id":		"users",
+					"parts":		[]interface{}{"cars"},
+					"previous":		"cars",
+				},
+			},
+			"trucks": map[string]interface{}{
+				"composed_of": []interface{}{"cars"},
+				"relations": []interface{}{"cars"},
+			},
+		},
+	}
+	f := fields(options.Validate(opt, newModule))
+	expected := []string{
+		"nouns.cars.relations.owner_id",
+		"nouns.cars.relations.parts",
+		"nouns.trucks.relations",
+	}
+	for _, v := range expected {
+		if !f[v] {
+			t.Fatal(v, f)
+		}
+	}
+	if len(f) != len(expected) {
+		t.Fatal(f)
+	}
+}
@@ -0,0 +1,211 @@
+// Package refs implements the reference fields of the input schemes and their expansion on read.
+// A reference field stores the id (or with "slice", the ids) of documents of an other noun:
+//	"Insert": {
+//		"input": {
+//			"author":	{"type": "ref", "noun": "users"},
+//			"tags":		{"type": "ref", "noun": "tags", "slice": true}
+//		}
+//	}
+// The Get and GetSingle verbs accept an expand modifier, eg. "/posts?expand=author,tags", which replaces the ids with the
+// referenced documents. The documents are loaded with one query per referenced noun, no matter how many documents are expanded.
+package refs
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/errs"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/misc/convert"
+	"github.com/opesun/sanitize"
+	"labix.org/v2/mgo/bson"
+	"sort"
+	"strings"
+)
+
+func ref(dat interface{}, s sanitize.Scheme) (interface{}, error) {
+	switch v := dat.(type) {
+	case bson.ObjectId:
+		return v, nil
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" {
+			if req, _ := s.Specific["required"].(bool); req {
+				return nil, fmt.Errorf("Required.")
+			}
+			return nil, nil
+		}
+		return convert.DecodeId(v)
+	case nil:
+		if req, _ := s.Specific["required"].(bool); req {
+			return nil, fmt.Errorf("Required.")
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("Must be an id.")
+}
+
+// Adds the ref field type to ex.
+func Add(ex *sanitize.Extractor) {
+	ex.AddFuncs(sanitize.FuncMap{
+		"ref": ref,
+	})
+}
+
+// Refs collects the reference fields of noun from the input schemes of its verbs. Returns field name => referenced noun.
+func Refs(opt map[string]interface{}, noun string) map[string]string {
+	ret := map[string]string{}
+	nm, _ := opt["nouns"].(map[string]interface{})
+	nounm, _ := nm[noun].(map[string]interface{})
+	verbs, _ := nounm["verbs"].(map[string]interface{})
+	for _, v := range verbs {
+		vm, _ := v.(map[string]interface{})
+		input, _ := vm["input"].(map[string]interface{})
+		for field, sch := range input {
+			schm, _ := sch.(map[string]interface{})
+			if typ, _ := schm["type"].(string); typ != "ref" {
+				continue
+			}
+			if to, ok := schm["noun"].(string); ok && to != "" {
+				ret[field] = to
+			}
+		}
+	}
+	return ret
+}
+
+// Fields parses the expand modifier, which is either a comma separated list, or given multiple times.
+func Fields(mod interface{}) []string {
+	var strs []string
+	switch v := mod.(type) {
+	case string:
+		strs = []string{v}
+	case []string:
+		strs = v
+	case []interface{}:
+		strs = convert.ToStringSlice(v...)
+	}
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, v := range strs {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "" || seen[f] {
+				continue
+			}
+			seen[f] = true
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// Nouns returns the nouns the given fields reference, or an error if one of them is not a reference field.
+func Nouns(refs map[string]string, fields []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, v := range fields {
+		to, has := refs[v]
+		if !has {
+			return nil, errs.New(errs.BadInput, "Can't expand %v, it is not a reference field.", v)
+		}
+		set[to] = true
+	}
+	ret := []string{}
+	for i := range set {
+		ret = append(ret, i)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func ids(val interface{}) []bson.ObjectId {
+	switch v := val.(type) {
+	case bson.ObjectId:
+		return []bson.ObjectId{v}
+	case []interface{}:
+		ret := []bson.ObjectId{}
+		for _, x := range v {
+			if id, ok := x.(bson.ObjectId); ok {
+				ret = append(ret, id)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+// Replaces the ids in val with the documents found, ids without a document are left as they are.
+func embed(val interface{}, found map[bson.ObjectId]map[string]interface{}) interface{} {
+	switch v := val.(type) {
+	case bson.ObjectId:
+		if doc, has := found[v]; has {
+			return doc
+		}
+	case []interface{}:
+		ret := []interface{}{}
+		for _, x := range v {
+			ret = append(ret, embed(x, found))
+		}
+		return ret
+	}
+	return val
+}
+
+// Expand replaces the ids in the given fields of docs with the referenced documents.
+// st is the storage of the site, refs is the result of Refs. The fields in forbidden (noun => field names) are left out of the
+// embedded documents.
+func Expand(st iface.Storage, docs []map[string]interface{}, refs map[string]string, fields []string, forbidden map[string][]string) error {
+	if _, err := Nouns(refs, fields); err != nil {
+		return err
+	}
+	by_noun := map[string][]string{}
+	for _, v := range fields {
+		by_noun[refs[v]] = append(by_noun[refs[v]], v)
+	}
+	for noun, nfields := range by_noun {
+		all := []interface{}{}
+		seen := map[bson.ObjectId]bool{}
+		for _, doc := range docs {
+			for _, f := range nfields {
+				for _, id := range ids(doc[f]) {
+					if !seen[id] {
+						seen[id] = true
+						all = append(all, id)
+					}
+				}
+			}
+		}
+		if len(all) == 0 {
+			continue
+		}
+		set := st.Set(noun)
+		set.Limit(0)
+		sel := map[string]int{}
+		for _, v := range forbidden[noun] {
+			sel[v] = 0
+		}
+		set.Select(sel)
+		res, err := set.Find(map[string]interface{}{"_id": map[string]interface{}{"$in": all}})
+		if err != nil {
+			return err
+		}
+		found := map[bson.ObjectId]map[string]interface{}{}
+		for _, v := range res {
+			doc, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, ok := doc["_id"].(bson.ObjectId)
+			if !ok {
+				continue
+			}
+			found[id] = doc
+		}
+		for _, doc := range docs {
+			for _, f := range nfields {
+				if val, has := doc[f]; has {
+					doc[f] = embed(val, found)
+				}
+			}
+		}
+	}
+	return nil
+}
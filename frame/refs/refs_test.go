@@ -0,0 +1,150 @@
+package refs_test
+
+import(
+	"github.com/opesun/chill/frame/errs"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/misc/convert"
+	"github.com/opesun/chill/frame/refs"
+	"github.com/opesun/chill/frame/set/memset"
+	"github.com/opesun/sanitize"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+// Counts the queries.
+type storage struct {
+	db		*memset.Db
+	finds	map[string]int
+}
+
+type set struct {
+	iface.Set
+	st	*storage
+}
+
+func (s set) Find(q map[string]interface{}) ([]interface{}, error) {
+	s.st.finds[s.Name()]++
+	return s.Set.Find(q)
+}
+
+func (s *storage) Set(coll string) iface.Set {
+	return set{memset.New(s.db, coll), s}
+}
+
+func (s *storage) Options() iface.OptionStore {
+	return nil
+}
+
+var opt = map[string]interface{}{
+	"nouns": map[string]interface{}{
+		"posts": map[string]interface{}{
+			"verbs": map[string]interface{}{
+				"Insert": map[string]interface{}{
+					"input": map[string]interface{}{
+						"title": 1,
+						"author": map[string]interface{}{"type": "ref", "noun": "users"},
+						"tags": map[string]interface{}{"type": "ref", "noun": "tags", "slice": true},
+					},
+				},
+				"Update": map[string]interface{}{
+					"input": map[string]interface{}{
+						"editor": map[string]interface{}{"type": "ref", "noun": "users"},
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestRefs(t *testing.T) {
+	r := refs.Refs(opt, "posts")
+	if len(r) != 3 || r["author"] != "users" || r["editor"] != "users" || r["tags"] != "tags" {
+		t.Fatal(r)
+	}
+	if len(refs.Refs(opt, "comments")) != 0 {
+		t.Fatal()
+	}
+	f := refs.Fields([]string{"author, tags", "author"})
+	if len(f) != 2 || f[0] != "author" || f[1] != "tags" {
+		t.Fatal(f)
+	}
+	_, err := refs.Nouns(r, []string{"title"})
+	if !errs.Is(err, errs.BadInput) {
+		t.Fatal(err)
+	}
+}
+
+func TestRefType(t *testing.T) {
+	id := bson.NewObjectId()
+	ex, err := sanitize.New(map[string]interface{}{
+		"author": map[string]interface{}{"type": "ref", "noun": "users"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs.Add(ex)
+	dat, err := ex.Extract(map[string]interface{}{"author": "  " + encode(id)})
+	if err != nil || dat["author"] != id {
+		t.Fatal(dat, err)
+	}
+	_, err = ex.Extract(map[string]interface{}{"author": "nonsense"})
+	if err == nil {
+		t.Fatal()
+	}
+}
+
+func encode(id bson.ObjectId) string {
+	doc := map[string]interface{}{"id": id}
+	convert.IdsToStrings(doc)
+	return doc["id"].(string)
+}
+
+func TestExpand(t *testing.T) {
+	st := &storage{memset.NewDb(), map[string]int{}}
+	john, jane := bson.NewObjectId(), bson.NewObjectId()
+	for _, v := range []bson.ObjectId{john, jane} {
+		err := st.Set("users").Insert(map[string]interface{}{"_id": v, "name": v.Hex(), "email": "x@example.com", "password": "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	gone := bson.NewObjectId()
+	tag := bson.NewObjectId()
+	err := st.Set("tags").Insert(map[string]interface{}{"_id": tag, "name": "go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := []map[string]interface{}{
+		{"title": "a", "author": john, "editor": jane, "tags": []interface{}{tag, gone}},
+		{"title": "b", "author": jane},
+		{"title": "c", "author": gone},
+	}
+	err = refs.Expand(st, docs, refs.Refs(opt, "posts"), []string{"author", "editor", "tags"}, map[string][]string{"users": {"email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.finds["users"] != 1 || st.finds["tags"] != 1 {
+		t.Fatal(st.finds)
+	}
+	author := docs[0]["author"].(map[string]interface{})
+	if author["_id"] != john || docs[0]["editor"].(map[string]interface{})["_id"] != jane {
+		t.Fatal(docs[0])
+	}
+	if _, has := author["password"]; has {
+		t.Fatal(author)
+	}
+	if _, has := author["email"]; has || author["name"] != john.Hex() {
+		t.Fatal(author)
+	}
+	tags := docs[0]["tags"].([]interface{})
+	if tags[0].(map[string]interface{})["name"] != "go" || tags[1] != gone {
+		t.Fatal(tags)
+	}
+	if docs[2]["author"] != gone {
+		t.Fatal(docs[2])
+	}
+	err = refs.Expand(st, docs, refs.Refs(opt, "posts"), []string{"title"}, nil)
+	if !errs.Is(err, errs.BadInput) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,385 @@
+// Package memset contains an in-memory implementation of iface.Set.
+// It understands the same query and update maps the filter package builds for MongoDB, so modules and whole request flows
+// can be run (and tested) without a database.
+package memset
+
+import(
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/indexes"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/query"
+	"labix.org/v2/mgo/bson"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errs.New(errs.NotFound, "Not found.")
+
+// Db holds the collections, it plays the role of the *mgo.Database.
+// It is safe for concurrent use.
+type Db struct {
+	mut		sync.RWMutex
+	colls	map[string][]map[string]interface{}
+	indexes	map[string][]iface.Index
+}
+
+func NewDb() *Db {
+	return &Db{
+		colls:		map[string][]map[string]interface{}{},
+		indexes:	map[string][]iface.Index{},
+	}
+}
+
+func New(db *Db, coll string) iface.Set {
+	return &Set{db, coll, 0, 0, nil, nil}
+}
+
+type Set struct {
+	db 		*Db
+	coll 	string
+	skip	int
+	limit	int
+	sort	[]string
+	sel		map[string]int
+}
+
+func (s *Set) Skip(i int) {
+	s.skip = i
+}
+
+func (s *Set) Limit(i int) {
+	s.limit = i
+}
+
+func (s *Set) Sort(str ...string) {
+	s.sort = str
+}
+
+// Select sets the projection of the results, eg. {"title": 1, "slug": 1} or {"body": 0}. The hidden fields are always left out,
+// see query.Hidden.
+func (s *Set) Select(sel map[string]int) {
+	s.sel = sel
+}
+
+func (s *Set) Name() string {
+	return s.coll
+}
+
+// Returns the indexes of the matching documents in the collection. The caller must hold the lock.
+func (s *Set) matching(q map[string]interface{}, max int) ([]int, error) {
+	ret := []int{}
+	for i, v := range s.db.colls[s.coll] {
+		ok, err := query.Match(v, q)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ret = append(ret, i)
+			if max > 0 && len(ret) == max {
+				break
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Removes the documents expired according to the TTL indexes. Called before the reads, since there is no background process doing it.
+func (s *Set) expire() {
+	s.db.mut.Lock()
+	defer s.db.mut.Unlock()
+	ttls := []iface.Index{}
+	for _, v := range s.db.indexes[s.coll] {
+		if v.TTL > 0 {
+			ttls = append(ttls, v)
+		}
+	}
+	if len(ttls) == 0 {
+		return
+	}
+	now := time.Now()
+	kept := []map[string]interface{}{}
+	for _, doc := range s.db.colls[s.coll] {
+		expired := false
+		for _, idx := range ttls {
+			if indexes.Expired(doc, idx, now) {
+				expired = true
+				break
+			}
+		}
+		if !expired {
+			kept = append(kept, doc)
+		}
+	}
+	s.db.colls[s.coll] = kept
+}
+
+// Returns the error of the first unique index doc violates, comparing it to every document of docs except the one at skip.
+// The caller must hold the lock.
+func (s *Set) violation(doc map[string]interface{}, docs []map[string]interface{}, skip int) error {
+	for i, v := range docs {
+		if i == skip {
+			continue
+		}
+		if query.Equal(v["_id"], doc["_id"]) {
+			return errs.AlreadyTaken("_id")
+		}
+		for _, idx := range s.db.indexes[s.coll] {
+			if indexes.Collide(doc, v, idx) {
+				return indexes.Taken(idx)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Set) Indexes() ([]iface.Index, error) {
+	s.db.mut.RLock()
+	defer s.db.mut.RUnlock()
+	return append([]iface.Index{}, s.db.indexes[s.coll]...), nil
+}
+
+// Fails if the documents already in the collection violate the index.
+func (s *Set) EnsureIndex(idx iface.Index) error {
+	if idx.Name == "" {
+		idx.Name = indexes.Name(idx)
+	}
+	s.db.mut.Lock()
+	defer s.db.mut.Unlock()
+	docs := s.db.colls[s.coll]
+	for i, a := range docs {
+		for _, b := range docs[i+1:] {
+			if indexes.Collide(a, b, idx) {
+				return errs.New(errs.Conflict, "Collection %v has duplicates for the unique index %v.", s.coll, idx.Name)
+			}
+		}
+	}
+	kept := []iface.Index{}
+	for _, v := range s.db.indexes[s.coll] {
+		if v.Name != idx.Name {
+			kept = append(kept, v)
+		}
+	}
+	s.db.indexes[s.coll] = append(kept, idx)
+	return nil
+}
+
+func (s *Set) DropIndex(name string) error {
+	s.db.mut.Lock()
+	defer s.db.mut.Unlock()
+	kept := []iface.Index{}
+	for _, v := range s.db.indexes[s.coll] {
+		if v.Name != name {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == len(s.db.indexes[s.coll]) {
+		return errs.New(errs.NotFound, "Index %v not found.", name)
+	}
+	s.db.indexes[s.coll] = kept
+	return nil
+}
+
+func (s *Set) FindOne(q map[string]interface{}) (map[string]interface{}, error) {
+	s.expire()
+	s.db.mut.RLock()
+	defer s.db.mut.RUnlock()
+	ind, err := s.matching(q, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(ind) == 0 {
+		return nil, ErrNotFound
+	}
+	return query.Project(query.CopyMap(s.db.colls[s.coll][ind[0]]), s.sel), nil
+}
+
+func (s *Set) Count(q map[string]interface{}) (int, error) {
+	s.expire()
+	s.db.mut.RLock()
+	defer s.db.mut.RUnlock()
+	ind, err := s.matching(q, 0)
+	if err != nil {
+		return 0, err
+	}
+	return len(ind), nil
+}
+
+func (s *Set) Find(q map[string]interface{}) ([]interface{}, error) {
+	s.expire()
+	s.db.mut.RLock()
+	ind, err := s.matching(q, 0)
+	if err != nil {
+		s.db.mut.RUnlock()
+		return nil, err
+	}
+	docs := []map[string]interface{}{}
+	for _, v := range ind {
+		docs = append(docs, query.CopyMap(s.db.colls[s.coll][v]))
+	}
+	s.db.mut.RUnlock()
+	query.Sort(docs, s.sort)
+	if s.skip > 0 {
+		if s.skip >= len(docs) {
+			docs = docs[:0]
+		} else {
+			docs = docs[s.skip:]
+		}
+	}
+	if s.limit > 0 && len(docs) > s.limit {
+		docs = docs[:s.limit]
+	}
+	ret := []interface{}{}
+	for _, v := range docs {
+		ret = append(ret, query.Project(v, s.sel))
+	}
+	return ret, nil
+}
+
+type cursor struct {
+	docs	[]interface{}
+}
+
+func (c *cursor) Next() (map[string]interface{}, bool) {
+	if len(c.docs) == 0 {
+		return nil, false
+	}
+	doc := c.docs[0].(map[string]interface{})
+	c.docs = c.docs[1:]
+	return doc, true
+}
+
+func (c *cursor) Err() error {
+	return nil
+}
+
+func (c *cursor) Close() error {
+	c.docs = nil
+	return nil
+}
+
+// Iter is like Find. The documents are in memory anyway, so they are copied at once, batch is not used.
+func (s *Set) Iter(q map[string]interface{}, batch int) (iface.Cursor, error) {
+	docs, err := s.Find(q)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor{docs}, nil
+}
+
+// Skip, Limit, Sort and Select are not used by Aggregate.
+func (s *Set) Aggregate(q map[string]interface{}, agg iface.Aggregation) ([]map[string]interface{}, error) {
+	a, err := query.NewAggregator(agg)
+	if err != nil {
+		return nil, errs.New(errs.BadInput, "%v", err)
+	}
+	s.expire()
+	s.db.mut.RLock()
+	defer s.db.mut.RUnlock()
+	ind, err := s.matching(q, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range ind {
+		a.Add(query.CopyMap(s.db.colls[s.coll][v]))
+	}
+	return a.Result(), nil
+}
+
+func (s *Set) Insert(d map[string]interface{}) error {
+	doc := query.CopyMap(d)
+	if _, has := doc["_id"]; !has {
+		doc["_id"] = bson.NewObjectId()
+	}
+	s.db.mut.Lock()
+	defer s.db.mut.Unlock()
+	if err := s.violation(doc, s.db.colls[s.coll], -1); err != nil {
+		return err
+	}
+	s.db.colls[s.coll] = append(s.db.colls[s.coll], doc)
+	return nil
+}
+
+func (s *Set) update(q map[string]interface{}, upd_query map[string]interface{}, max int) (int, error) {
+	s.db.mut.Lock()
+	defer s.db.mut.Unlock()
+	ind, err := s.matching(q, max)
+	if err != nil {
+		return 0, err
+	}
+	coll := s.db.colls[s.coll]
+	updated := make([]map[string]interface{}, len(ind))
+	// Applying every update before writing any of them back, so a bad update query leaves the collection untouched.
+	for i, v := range ind {
+		doc, err := query.Update(coll[v], upd_query)
+		if err != nil {
+			return 0, err
+		}
+		updated[i] = doc
+	}
+	next := append([]map[string]interface{}{}, coll...)
+	for i, v := range ind {
+		next[v] = updated[i]
+	}
+	for _, v := range ind {
+		if err := s.violation(next[v], next, v); err != nil {
+			return 0, err
+		}
+	}
+	for i, v := range ind {
+		coll[v] = updated[i]
+	}
+	return len(ind), nil
+}
+
+func (s *Set) Update(q map[string]interface{}, upd_query map[string]interface{}) error {
+	c, err := s.update(q, upd_query, 1)
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Set) UpdateAll(q map[string]interface{}, upd_query map[string]interface{}) (int, error) {
+	return s.update(q, upd_query, 0)
+}
+
+func (s *Set) remove(q map[string]interface{}, max int) (int, error) {
+	s.db.mut.Lock()
+	defer s.db.mut.Unlock()
+	ind, err := s.matching(q, max)
+	if err != nil {
+		return 0, err
+	}
+	if len(ind) == 0 {
+		return 0, nil
+	}
+	kept := []map[string]interface{}{}
+	j := 0
+	for i, v := range s.db.colls[s.coll] {
+		if j < len(ind) && ind[j] == i {
+			j++
+			continue
+		}
+		kept = append(kept, v)
+	}
+	s.db.colls[s.coll] = kept
+	return len(ind), nil
+}
+
+func (s *Set) Remove(q map[string]interface{}) error {
+	c, err := s.remove(q, 1)
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Set) RemoveAll(q map[string]interface{}) (int, error) {
+	return s.remove(q, 0)
+}
@@ -0,0 +1,263 @@
+package memset_test
+
+import(
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/filter"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/memset"
+	"labix.org/v2/mgo/bson"
+	"testing"
+	"time"
+)
+
+type MockEvent struct {}
+
+func (m MockEvent) Fire(s string, params ...interface{}) {
+}
+
+func (m MockEvent) Iterate(s string, ret_rec interface{}, params ...interface{}) {
+}
+
+func fill(t *testing.T, docs ...map[string]interface{}) *memset.Set {
+	set := memset.New(memset.NewDb(), "cars").(*memset.Set)
+	for _, v := range docs {
+		err := set.Insert(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return set
+}
+
+func cars(t *testing.T) *memset.Set {
+	return fill(t,
+		map[string]interface{}{"make": "bmw", "year": 2001, "tags": []interface{}{"fast", "german"}},
+		map[string]interface{}{"make": "audi", "year": 1999, "tags": []interface{}{"german"}},
+		map[string]interface{}{"make": "fiat", "year": 2010},
+	)
+}
+
+func TestFindSortSkipLimit(t *testing.T) {
+	set := cars(t)
+	set.Sort("-year")
+	set.Skip(1)
+	set.Limit(1)
+	docs, err := set.Find(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].(map[string]interface{})["make"] != "bmw" {
+		t.Fatal(docs)
+	}
+	c, err := set.Count(nil)
+	if err != nil || c != 3 {
+		t.Fatal(c, err)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	set := cars(t)
+	queries := []struct{
+		q		map[string]interface{}
+		count	int
+	}{
+		{map[string]interface{}{"tags": "german"}, 2},
+		{map[string]interface{}{"make": map[string]interface{}{"$in": []interface{}{"bmw", "fiat"}}}, 2},
+		{map[string]interface{}{"$and": []interface{}{
+			map[string]interface{}{"make": bson.RegEx{Pattern: "^b"}},
+			map[string]interface{}{"tags": "fast"},
+		}}, 1},
+		{map[string]interface{}{"year": map[string]interface{}{"$gte": 2001}}, 2},
+		{map[string]interface{}{"tags": map[string]interface{}{"$exists": false}}, 1},
+		{map[string]interface{}{"tags": map[string]interface{}{"$all": []interface{}{"fast", "german"}}}, 1},
+		{map[string]interface{}{"tags": map[string]interface{}{"$all": []interface{}{}}}, 0},
+	}
+	for _, v := range queries {
+		c, err := set.Count(v.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != v.count {
+			t.Fatal(v.q, c)
+		}
+	}
+	_, err := set.Count(map[string]interface{}{"$where": "1"})
+	if err == nil {
+		t.Fatal()
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	set := cars(t)
+	q := map[string]interface{}{"make": "bmw"}
+	upds := []map[string]interface{}{
+		{"$set": map[string]interface{}{"color": "red"}},
+		{"$addToSet": map[string]interface{}{"tags": map[string]interface{}{"$each": []interface{}{"fast", "red"}}}},
+		{"$pull": map[string]interface{}{"tags": "german"}},
+		{"$unset": map[string]interface{}{"year": 1}},
+	}
+	for _, v := range upds {
+		err := set.Update(q, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc, err := set.FindOne(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := doc["tags"].([]interface{})
+	if doc["color"] != "red" || len(tags) != 2 || tags[0] != "fast" || tags[1] != "red" || doc["year"] != nil {
+		t.Fatal(doc)
+	}
+	err = set.Update(map[string]interface{}{"make": "skoda"}, upds[0])
+	if err != memset.ErrNotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestNoTampering(t *testing.T) {
+	set := cars(t)
+	q := map[string]interface{}{"make": "bmw"}
+	doc, _ := set.FindOne(q)
+	doc["tags"].([]interface{})[0] = "slow"
+	doc, _ = set.FindOne(q)
+	if doc["tags"].([]interface{})[0] != "fast" {
+		t.Fatal(doc)
+	}
+}
+
+// A request flow, filter and Set together: reduced parents, inserting into and querying a child collection.
+func TestFilterFlow(t *testing.T) {
+	db := memset.NewDb()
+	ev := &MockEvent{}
+	make_set := memset.New(db, "cars")
+	for _, v := range []string{"bmw", "audi"} {
+		make_set.Insert(map[string]interface{}{"make": v})
+	}
+	comments := memset.New(db, "comments")
+	cf := filter.New(make_set, ev, map[string]interface{}{"make": "bmw"})
+	ids, err := cf.Ids()
+	if err != nil || len(ids) != 1 {
+		t.Fatal(ids, err)
+	}
+	com := filter.New(comments, ev, nil).AddParents("_cars", ids)
+	err = com.Insert(map[string]interface{}{"text": "Nice car."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments.Insert(map[string]interface{}{"text": "Unrelated."})
+	docs, err := com.Find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].(map[string]interface{})["text"] != "Nice car." {
+		t.Fatal(docs)
+	}
+	c, err := com.RemoveAll()
+	if err != nil || c != 1 {
+		t.Fatal(c, err)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	set := cars(t)
+	idx := iface.Index{Name: "chill_make_1", Key: []string{"make"}, Unique: true}
+	err := set.EnsureIndex(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Insert(map[string]interface{}{"make": "bmw"})
+	if !errs.Is(err, errs.Conflict) || errs.FieldsOf(err)["make"] != "Already taken." {
+		t.Fatal(err)
+	}
+	err = set.Update(map[string]interface{}{"make": "fiat"}, map[string]interface{}{"$set": map[string]interface{}{"make": "audi"}})
+	if errs.FieldsOf(err)["make"] != "Already taken." {
+		t.Fatal(err)
+	}
+	// Documents without the field are indexed as null.
+	err = set.Insert(map[string]interface{}{"year": 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Insert(map[string]interface{}{"year": 2002})
+	if errs.FieldsOf(err)["make"] != "Already taken." {
+		t.Fatal(err)
+	}
+	// But not by sparse ones.
+	err = set.DropIndex(idx.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Sparse = true
+	err = set.EnsureIndex(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Insert(map[string]interface{}{"year": 2002})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idxs, err := set.Indexes()
+	if err != nil || len(idxs) != 1 || !idxs[0].Sparse {
+		t.Fatal(idxs, err)
+	}
+	// Can't be created over duplicates.
+	err = set.EnsureIndex(iface.Index{Name: "chill_tags_1", Key: []string{"tags"}, Unique: true})
+	if !errs.Is(err, errs.Conflict) {
+		t.Fatal(err)
+	}
+	if !errs.Is(set.DropIndex("nope"), errs.NotFound) {
+		t.Fatal()
+	}
+}
+
+func TestTTLIndex(t *testing.T) {
+	now := time.Now()
+	set := fill(t,
+		map[string]interface{}{"make": "bmw", "expires": now.Add(-2 * time.Hour).Unix()},
+		map[string]interface{}{"make": "audi", "expires": now},
+		map[string]interface{}{"make": "fiat"},
+	)
+	err := set.EnsureIndex(iface.Index{Name: "chill_expires_1", Key: []string{"expires"}, TTL: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := set.Count(nil)
+	if err != nil || c != 2 {
+		t.Fatal(c, err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	set := fill(t,
+		map[string]interface{}{"name": "john", "password": "x", "address": map[string]interface{}{"city": "Rome", "zip": "1"}},
+	)
+	set.Select(map[string]int{"address.city": 1, "password": 1})
+	doc, err := set.FindOne(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 2 || doc["_id"] == nil || len(doc["address"].(map[string]interface{})) != 1 {
+		t.Fatal(doc)
+	}
+	set.Select(map[string]int{"address": 0})
+	docs, err := set.Find(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = docs[0].(map[string]interface{})
+	if len(doc) != 2 || doc["name"] != "john" {
+		t.Fatal(doc)
+	}
+	// Hidden fields are never returned, but they are still stored.
+	set.Select(nil)
+	c, err := set.Count(map[string]interface{}{"password": "x"})
+	if err != nil || c != 1 {
+		t.Fatal(c, err)
+	}
+	doc, err = set.FindOne(nil)
+	if _, has := doc["password"]; has || err != nil {
+		t.Fatal(doc, err)
+	}
+}
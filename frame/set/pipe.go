@@ -0,0 +1,104 @@
+package set
+
+import(
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/query"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// The formats of the date buckets for $dateToString, see the ones of query.Aggregator.
+var bucket_formats = map[string]string{
+	"hour":		"%Y-%m-%dT%H",
+	"day":		"%Y-%m-%d",
+	"month":	"%Y-%m",
+	"year":		"%Y",
+}
+
+func cond(if_, then, else_ interface{}) map[string]interface{} {
+	return map[string]interface{}{"$cond": []interface{}{if_, then, else_}}
+}
+
+// The bucket of a field, like query.Aggregator does it: numbers are taken as Unix time in seconds, milliseconds, microseconds
+// or nanoseconds depending on their magnitude, other values which are not dates are left as they are.
+func bucketExpr(field, unit string) interface{} {
+	v := "$" + field
+	if unit == "" {
+		return v
+	}
+	lt := func(n float64) interface{} {
+		return map[string]interface{}{"$lt": []interface{}{v, n}}
+	}
+	div := func(n float64) interface{} {
+		return map[string]interface{}{"$divide": []interface{}{v, n}}
+	}
+	ms := cond(lt(1e11), map[string]interface{}{"$multiply": []interface{}{v, 1000}},
+		cond(lt(1e14), v,
+			cond(lt(1e17), div(1e3), div(1e6))))
+	format := func(date interface{}) interface{} {
+		return map[string]interface{}{"$dateToString": map[string]interface{}{"format": bucket_formats[unit], "date": date}}
+	}
+	typ := map[string]interface{}{"$type": v}
+	return cond(map[string]interface{}{"$in": []interface{}{typ, []interface{}{"double", "int", "long", "decimal"}}},
+		format(map[string]interface{}{"$add": []interface{}{time.Unix(0, 0).UTC(), ms}}),
+		cond(map[string]interface{}{"$eq": []interface{}{typ, "date"}}, format(v), v))
+}
+
+// Translates agg into an aggregation pipeline. Every group field is unwound, and the group fields are put into the _id of
+// the groups under their column names (see query.Column). Needs MongoDB 3.4 or newer.
+// Just like at query.Aggregator, a document is put into a group for every element of an array group field, so it is counted,
+// summed and averaged in all of those groups.
+func pipeline(q map[string]interface{}, agg iface.Aggregation) []interface{} {
+	pipe := []interface{}{map[string]interface{}{"$match": q}}
+	id := map[string]interface{}{}
+	sort := bson.D{}
+	for _, v := range agg.By {
+		pipe = append(pipe, map[string]interface{}{"$unwind": map[string]interface{}{"path": "$" + v.Field, "preserveNullAndEmptyArrays": true}})
+		id[query.Column(v.Field)] = bucketExpr(v.Field, v.Bucket)
+		sort = append(sort, bson.DocElem{Name: "_id." + query.Column(v.Field), Value: 1})
+	}
+	group := map[string]interface{}{"_id": id}
+	for _, v := range agg.Ops {
+		var acc interface{}
+		switch v.Op {
+		case "count":
+			acc = map[string]interface{}{"$sum": 1}
+		case "distinct":
+			acc = map[string]interface{}{"$addToSet": "$" + v.Field}
+		default:
+			acc = map[string]interface{}{"$" + v.Op: "$" + v.Field}
+		}
+		group[query.OpName(v)] = acc
+	}
+	pipe = append(pipe, map[string]interface{}{"$group": group})
+	if len(sort) > 0 {
+		pipe = append(pipe, map[string]interface{}{"$sort": sort})
+	}
+	return pipe
+}
+
+// Converts a group coming from the pipeline into a row like the ones of query.Aggregator.
+func aggRow(group map[string]interface{}, agg iface.Aggregation) map[string]interface{} {
+	row := map[string]interface{}{}
+	id, _ := group["_id"].(map[string]interface{})
+	for _, v := range agg.By {
+		row[query.Column(v.Field)] = id[query.Column(v.Field)]
+	}
+	for _, v := range agg.Ops {
+		val := group[query.OpName(v)]
+		switch v.Op {
+		case "sum":
+			switch n := val.(type) {
+			case int:
+				val = float64(n)
+			case int64:
+				val = float64(n)
+			}
+		case "distinct":
+			sl, _ := val.([]interface{})
+			val = query.Distinct(sl)
+		}
+		row[query.OpName(v)] = val
+	}
+	return row
+}
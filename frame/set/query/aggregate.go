@@ -0,0 +1,258 @@
+package query
+
+import(
+	"fmt"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"strings"
+	"time"
+)
+
+var bucket_formats = map[string]string{
+	"hour":		"2006-01-02T15",
+	"day":		"2006-01-02",
+	"month":	"2006-01",
+	"year":		"2006",
+}
+
+// Converts a timestamp to time. Numbers are taken as Unix time, in seconds, milliseconds, microseconds or nanoseconds
+// depending on their magnitude, since all of them are used in the documents.
+func toTime(v interface{}) (time.Time, bool) {
+	if t, ok := v.(time.Time); ok {
+		return t.UTC(), true
+	}
+	f, ok := isNum(v)
+	if !ok {
+		return time.Time{}, false
+	}
+	n := int64(f)
+	switch {
+	case n < 1e11:
+		return time.Unix(n, 0).UTC(), true
+	case n < 1e14:
+		return time.Unix(0, n * 1e6).UTC(), true
+	case n < 1e17:
+		return time.Unix(0, n * 1e3).UTC(), true
+	}
+	return time.Unix(0, n).UTC(), true
+}
+
+// The bucket of a timestamp, eg. "2013-05-21" for days. Values which are not timestamps are left as they are.
+func bucket(v interface{}, unit string) interface{} {
+	if unit == "" {
+		return v
+	}
+	t, ok := toTime(v)
+	if !ok {
+		return v
+	}
+	return t.Format(bucket_formats[unit])
+}
+
+// Name of the result field of a group field, the dots of the nested fields are replaced, eg. "author_id" for "author.id".
+func Column(field string) string {
+	return strings.Replace(field, ".", "_", -1)
+}
+
+// Name of the result field of an operation, eg. "sum_total".
+func OpName(op iface.AggOp) string {
+	if op.Field == "" {
+		return op.Op
+	}
+	return op.Op + "_" + Column(op.Field)
+}
+
+type accum struct {
+	count	int
+	sum		float64
+	nums	int
+	min		interface{}
+	max		interface{}
+	seen	map[string]bool
+	values	[]interface{}
+}
+
+type group struct {
+	key		[]interface{}
+	accs	[]*accum
+}
+
+// Aggregator computes an aggregation over documents fed to it one by one, so the documents don't have to be in memory at once.
+// Used by the iface.Set implementations, see iface.Aggregation.
+type Aggregator struct {
+	agg		iface.Aggregation
+	groups	map[string]*group
+	order	[]string
+}
+
+func NewAggregator(agg iface.Aggregation) (*Aggregator, error) {
+	for _, v := range agg.By {
+		if _, ok := bucket_formats[v.Bucket]; v.Bucket != "" && !ok {
+			return nil, fmt.Errorf("Unknown date bucket %v.", v.Bucket)
+		}
+	}
+	for _, v := range agg.Ops {
+		switch v.Op {
+		case "count":
+		case "sum", "avg", "min", "max", "distinct":
+			if v.Field == "" {
+				return nil, fmt.Errorf("Operation %v needs a field.", v.Op)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown operation %v.", v.Op)
+		}
+	}
+	return &Aggregator{agg: agg, groups: map[string]*group{}}, nil
+}
+
+// The group keys of doc. Array values put the document into a group for every element, like $unwind.
+func (a *Aggregator) keys(doc map[string]interface{}) [][]interface{} {
+	ret := [][]interface{}{{}}
+	for _, by := range a.agg.By {
+		v, _ := Get(doc, by.Field)
+		vals := []interface{}{v}
+		if sl, ok := ToSlice(v); ok && len(sl) > 0 {
+			vals = sl
+		}
+		next := [][]interface{}{}
+		for _, k := range ret {
+			for _, x := range vals {
+				next = append(next, append(append([]interface{}{}, k...), bucket(x, by.Bucket)))
+			}
+		}
+		ret = next
+	}
+	return ret
+}
+
+func valueKey(v interface{}) string {
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// Add feeds a document to the aggregation.
+func (a *Aggregator) Add(doc map[string]interface{}) {
+	for _, key := range a.keys(doc) {
+		id := valueKey(key)
+		g, has := a.groups[id]
+		if !has {
+			g = &group{key: key}
+			for range a.agg.Ops {
+				g.accs = append(g.accs, &accum{seen: map[string]bool{}})
+			}
+			a.groups[id] = g
+			a.order = append(a.order, id)
+		}
+		for i, op := range a.agg.Ops {
+			acc := g.accs[i]
+			acc.count++
+			if op.Field == "" {
+				continue
+			}
+			v, has := Get(doc, op.Field)
+			if !has {
+				continue
+			}
+			vals := []interface{}{v}
+			if sl, ok := ToSlice(v); ok {
+				vals = sl
+			}
+			for _, x := range vals {
+				acc.add(x)
+			}
+		}
+	}
+}
+
+func (acc *accum) add(v interface{}) {
+	if v == nil {
+		return
+	}
+	if f, ok := isNum(v); ok {
+		acc.sum += f
+		acc.nums++
+	}
+	if acc.min == nil || Compare(v, acc.min) < 0 {
+		acc.min = v
+	}
+	if acc.max == nil || Compare(v, acc.max) > 0 {
+		acc.max = v
+	}
+	if k := valueKey(v); !acc.seen[k] {
+		acc.seen[k] = true
+		acc.values = append(acc.values, v)
+	}
+}
+
+func (acc *accum) result(op string) interface{} {
+	switch op {
+	case "count":
+		return acc.count
+	case "sum":
+		return acc.sum
+	case "avg":
+		if acc.nums == 0 {
+			return nil
+		}
+		return acc.sum / float64(acc.nums)
+	case "min":
+		return acc.min
+	case "max":
+		return acc.max
+	case "distinct":
+		return Distinct(acc.values)
+	}
+	return nil
+}
+
+// Distinct returns the different values of vals in order, the elements of the array values are taken one by one.
+func Distinct(vals []interface{}) []interface{} {
+	seen := map[string]bool{}
+	docs := []map[string]interface{}{}
+	for _, v := range vals {
+		elems := []interface{}{v}
+		if sl, ok := ToSlice(v); ok {
+			elems = sl
+		}
+		for _, x := range elems {
+			if k := valueKey(x); x != nil && !seen[k] {
+				seen[k] = true
+				docs = append(docs, map[string]interface{}{"v": x})
+			}
+		}
+	}
+	Sort(docs, []string{"v"})
+	ret := []interface{}{}
+	for _, v := range docs {
+		ret = append(ret, v["v"])
+	}
+	return ret
+}
+
+// Result returns a row for every group, holding the group fields and the results of the operations (see OpName),
+// ordered by the group fields. Returns a single row if there is no group field, even if no document was added.
+func (a *Aggregator) Result() []map[string]interface{} {
+	if len(a.agg.By) == 0 && len(a.groups) == 0 {
+		a.groups[""] = &group{}
+		for range a.agg.Ops {
+			a.groups[""].accs = append(a.groups[""].accs, &accum{seen: map[string]bool{}})
+		}
+		a.order = append(a.order, "")
+	}
+	ret := []map[string]interface{}{}
+	for _, id := range a.order {
+		g := a.groups[id]
+		row := map[string]interface{}{}
+		for i, by := range a.agg.By {
+			row[Column(by.Field)] = g.key[i]
+		}
+		for i, op := range a.agg.Ops {
+			row[OpName(op)] = g.accs[i].result(op.Op)
+		}
+		ret = append(ret, row)
+	}
+	keys := []string{}
+	for _, v := range a.agg.By {
+		keys = append(keys, Column(v.Field))
+	}
+	Sort(ret, keys)
+	return ret
+}
@@ -0,0 +1,787 @@
+// Package query evaluates the MongoDB style query and update maps (the ones built by the filter package) on plain documents.
+// It is used by the iface.Set implementations which can't hand these maps to a MongoDB server.
+package query
+
+import(
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deep copies a document (or any value found in a document), so the stored version can't be tampered with by the caller.
+// bson.M s are converted to map[string]interface{} s along the way.
+func Copy(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return CopyMap(val)
+	case bson.M:
+		return CopyMap(map[string]interface{}(val))
+	case []interface{}:
+		ret := make([]interface{}, len(val))
+		for i, x := range val {
+			ret[i] = Copy(x)
+		}
+		return ret
+	case []string:
+		ret := make([]interface{}, len(val))
+		for i, x := range val {
+			ret[i] = x
+		}
+		return ret
+	case []bson.ObjectId:
+		ret := make([]interface{}, len(val))
+		for i, x := range val {
+			ret[i] = x
+		}
+		return ret
+	}
+	return v
+}
+
+func CopyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	ret := make(map[string]interface{}, len(m))
+	for i, v := range m {
+		ret[i] = Copy(v)
+	}
+	return ret
+}
+
+// Turns any kind of slice (eg. []bson.ObjectId coming from the parents of a filter) into an []interface{}.
+func ToSlice(v interface{}) ([]interface{}, bool) {
+	if sl, ok := v.([]interface{}); ok {
+		return sl, true
+	}
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	ret := make([]interface{}, rv.Len())
+	for i := range ret {
+		ret[i] = rv.Index(i).Interface()
+	}
+	return ret, true
+}
+
+func toMap(v interface{}) (map[string]interface{}, bool) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return val, true
+	case bson.M:
+		return map[string]interface{}(val), true
+	}
+	return nil, false
+}
+
+// Gets a value from a document by a dot separated access path, eg. "author.name".
+func Get(doc map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, v := range strings.Split(path, ".") {
+		m, ok := toMap(cur)
+		if !ok {
+			return nil, false
+		}
+		cur, ok = m[v]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// Sets a value in a document by a dot separated access path, creating the missing maps.
+func set(doc map[string]interface{}, path string, val interface{}) error {
+	p := strings.Split(path, ".")
+	cur := doc
+	for _, v := range p[:len(p)-1] {
+		next, has := cur[v]
+		if !has {
+			m := map[string]interface{}{}
+			cur[v] = m
+			cur = m
+			continue
+		}
+		m, ok := toMap(next)
+		if !ok {
+			return fmt.Errorf("Can't set %v, %v is not a map.", path, v)
+		}
+		cur = m
+	}
+	cur[p[len(p)-1]] = val
+	return nil
+}
+
+func unset(doc map[string]interface{}, path string) {
+	p := strings.Split(path, ".")
+	cur := doc
+	for _, v := range p[:len(p)-1] {
+		m, ok := toMap(cur[v])
+		if !ok {
+			return
+		}
+		cur = m
+	}
+	delete(cur, p[len(p)-1])
+}
+
+func isNum(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case int:
+		return float64(val), true
+	case int8:
+		return float64(val), true
+	case int16:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint:
+		return float64(val), true
+	case uint8:
+		return float64(val), true
+	case uint16:
+		return float64(val), true
+	case uint32:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
+
+func isInt(v interface{}) (int64, bool) {
+	switch val := v.(type) {
+	case int:
+		return int64(val), true
+	case int32:
+		return int64(val), true
+	case int64:
+		return val, true
+	}
+	return 0, false
+}
+
+// Order of types when comparing values of different types, roughly follows the one of MongoDB.
+func typeRank(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	if _, ok := isNum(v); ok {
+		return 1
+	}
+	switch v.(type) {
+	case string:
+		return 2
+	case map[string]interface{}, bson.M:
+		return 3
+	case bson.ObjectId:
+		return 5
+	case bool:
+		return 6
+	case time.Time:
+		return 7
+	}
+	if _, ok := ToSlice(v); ok {
+		return 4
+	}
+	return 8
+}
+
+// Compares two values, returns -1, 0 or 1.
+func Compare(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch ra {
+	case 0:
+		return 0
+	case 1:
+		// Comparing as int64s when possible, big timestamps (eg. UnixNano) lose precision as float64s.
+		ia, oka := isInt(a)
+		ib, okb := isInt(b)
+		if oka && okb {
+			return cmpInt(ia, ib)
+		}
+		fa, _ := isNum(a)
+		fb, _ := isNum(b)
+		if fa < fb {
+			return -1
+		} else if fa > fb {
+			return 1
+		}
+		return 0
+	case 2:
+		return strings.Compare(a.(string), b.(string))
+	case 3:
+		am, _ := toMap(a)
+		bm, _ := toMap(b)
+		return compareMaps(am, bm)
+	case 4:
+		as, _ := ToSlice(a)
+		bs, _ := ToSlice(b)
+		for i := 0; i < len(as) && i < len(bs); i++ {
+			if c := Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(int64(len(as)), int64(len(bs)))
+	case 5:
+		return strings.Compare(string(a.(bson.ObjectId)), string(b.(bson.ObjectId)))
+	case 6:
+		ab, bb := a.(bool), b.(bool)
+		if ab == bb {
+			return 0
+		} else if !ab {
+			return -1
+		}
+		return 1
+	case 7:
+		at, bt := a.(time.Time), b.(time.Time)
+		if at.Before(bt) {
+			return -1
+		} else if at.After(bt) {
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func cmpInt(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareMaps(a, b map[string]interface{}) int {
+	ak := sortedKeys(a)
+	bk := sortedKeys(b)
+	for i := 0; i < len(ak) && i < len(bk); i++ {
+		if c := strings.Compare(ak[i], bk[i]); c != 0 {
+			return c
+		}
+		if c := Compare(a[ak[i]], b[bk[i]]); c != 0 {
+			return c
+		}
+	}
+	return cmpInt(int64(len(ak)), int64(len(bk)))
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for i := range m {
+		keys = append(keys, i)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func Equal(a, b interface{}) bool {
+	return Compare(a, b) == 0
+}
+
+// Does a field value (which can be an array) match the given value, eg. {"tags": "go"} matches {"tags": ["go", "mongo"]}.
+func matchValue(field interface{}, exists bool, val interface{}) (bool, error) {
+	if re, ok, err := toRegexp(val); ok {
+		if err != nil {
+			return false, err
+		}
+		return matchRegexp(field, re), nil
+	}
+	if !exists {
+		return val == nil, nil
+	}
+	if Equal(field, val) {
+		return true, nil
+	}
+	if _, is_slice := val.([]interface{}); !is_slice {
+		if sl, ok := ToSlice(field); ok && typeRank(field) == 4 {
+			for _, v := range sl {
+				if Equal(v, val) {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+func toRegexp(v interface{}) (*regexp.Regexp, bool, error) {
+	switch val := v.(type) {
+	case bson.RegEx:
+		re, err := compileRegexp(val.Pattern, val.Options)
+		return re, true, err
+	case *regexp.Regexp:
+		return val, true, nil
+	}
+	return nil, false, nil
+}
+
+func compileRegexp(pattern, options string) (*regexp.Regexp, error) {
+	flags := ""
+	for _, v := range options {
+		switch v {
+		case 'i', 'm', 's':
+			flags += string(v)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+func matchRegexp(field interface{}, re *regexp.Regexp) bool {
+	if s, ok := field.(string); ok {
+		return re.MatchString(s)
+	}
+	if sl, ok := ToSlice(field); ok && typeRank(field) == 4 {
+		for _, v := range sl {
+			if s, ok := v.(string); ok && re.MatchString(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func IsOperatorMap(v interface{}) (map[string]interface{}, bool) {
+	m, ok := toMap(v)
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	for i := range m {
+		if !strings.HasPrefix(i, "$") {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// Does the value of a field (or any of its elements if it is an array) satisfy f.
+func anyOf(field interface{}, f func(interface{}) bool) bool {
+	if f(field) {
+		return true
+	}
+	if sl, ok := ToSlice(field); ok && typeRank(field) == 4 {
+		for _, v := range sl {
+			if f(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchOperators(field interface{}, exists bool, ops map[string]interface{}) (bool, error) {
+	for op, arg := range ops {
+		var ok bool
+		var err error
+		switch op {
+		case "$eq":
+			ok, err = matchValue(field, exists, arg)
+		case "$ne":
+			ok, err = matchValue(field, exists, arg)
+			ok = !ok
+		case "$in", "$nin":
+			vals, is_slice := ToSlice(arg)
+			if !is_slice {
+				return false, fmt.Errorf("Argument of %v must be an array.", op)
+			}
+			for _, v := range vals {
+				ok, err = matchValue(field, exists, v)
+				if err != nil || ok {
+					break
+				}
+			}
+			if op == "$nin" {
+				ok = !ok
+			}
+		case "$all":
+			vals, is_slice := ToSlice(arg)
+			if !is_slice {
+				return false, fmt.Errorf("Argument of $all must be an array.")
+			}
+			ok = exists && len(vals) > 0		// Like at MongoDB, an empty list matches nothing.
+			for _, v := range vals {
+				var m bool
+				m, err = matchValue(field, exists, v)
+				if err != nil || !m {
+					ok = false
+					break
+				}
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = exists && anyOf(field, func(v interface{}) bool {
+				if typeRank(v) != typeRank(arg) {
+					return false
+				}
+				c := Compare(v, arg)
+				switch op {
+				case "$gt":
+					return c > 0
+				case "$gte":
+					return c >= 0
+				case "$lt":
+					return c < 0
+				}
+				return c <= 0
+			})
+		case "$exists":
+			want, _ := arg.(bool)
+			if n, is_num := isNum(arg); is_num {
+				want = n != 0
+			}
+			ok = exists == want
+		case "$regex":
+			var pattern string
+			switch p := arg.(type) {
+			case string:
+				pattern = p
+			case bson.RegEx:
+				pattern = p.Pattern
+			default:
+				return false, fmt.Errorf("Argument of $regex must be a string.")
+			}
+			options, _ := ops["$options"].(string)
+			var re *regexp.Regexp
+			re, err = compileRegexp(pattern, options)
+			ok = err == nil && exists && matchRegexp(field, re)
+		case "$options":
+			ok = true
+		case "$size":
+			n, is_num := isNum(arg)
+			sl, is_slice := ToSlice(field)
+			ok = is_num && is_slice && exists && len(sl) == int(n)
+		case "$not":
+			sub, is_ops := IsOperatorMap(arg)
+			if is_ops {
+				ok, err = matchOperators(field, exists, sub)
+			} else {
+				ok, err = matchValue(field, exists, arg)
+			}
+			ok = !ok
+		default:
+			return false, fmt.Errorf("Unknown query operator %v.", op)
+		}
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func subQueries(op string, v interface{}) ([]map[string]interface{}, error) {
+	sl, ok := ToSlice(v)
+	if !ok {
+		return nil, fmt.Errorf("Argument of %v must be an array.", op)
+	}
+	ret := []map[string]interface{}{}
+	for _, x := range sl {
+		m, ok := toMap(x)
+		if !ok {
+			return nil, fmt.Errorf("Elements of %v must be maps.", op)
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+// Decides if doc satisfies query q.
+// Supported: equality (also on array members), regexes, $and, $or, $nor, $in, $nin, $all, $ne, $eq, $gt, $gte, $lt, $lte, $exists, $regex, $size, $not.
+func Match(doc map[string]interface{}, q map[string]interface{}) (bool, error) {
+	for key, val := range q {
+		var ok bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			subs, serr := subQueries(key, val)
+			if serr != nil {
+				return false, serr
+			}
+			ok = key == "$and"
+			for _, v := range subs {
+				var m bool
+				m, err = Match(doc, v)
+				if err != nil {
+					return false, err
+				}
+				if key == "$and" && !m {
+					ok = false
+					break
+				}
+				if key != "$and" && m {
+					ok = true
+					break
+				}
+			}
+			if key == "$nor" {
+				ok = !ok
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("Unknown query operator %v.", key)
+			}
+			field, exists := Get(doc, key)
+			if ops, is_ops := IsOperatorMap(val); is_ops {
+				ok, err = matchOperators(field, exists, ops)
+			} else {
+				ok, err = matchValue(field, exists, val)
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func isUpdateOperators(upd map[string]interface{}) bool {
+	for i := range upd {
+		if strings.HasPrefix(i, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// Values of $addToSet and $push can be given as {"$each": [...]}.
+func each(v interface{}) []interface{} {
+	if m, ok := toMap(v); ok {
+		if e, has := m["$each"]; has {
+			if sl, ok := ToSlice(e); ok {
+				return sl
+			}
+		}
+	}
+	return []interface{}{v}
+}
+
+func fieldArray(doc map[string]interface{}, path, op string) ([]interface{}, error) {
+	cur, has := Get(doc, path)
+	if !has || cur == nil {
+		return []interface{}{}, nil
+	}
+	sl, ok := ToSlice(cur)
+	if !ok || typeRank(cur) != 4 {
+		return nil, fmt.Errorf("Can't apply %v to non array field %v.", op, path)
+	}
+	return append([]interface{}{}, sl...), nil
+}
+
+// Applies an update document to doc, returns the updated document, doc itself is left untouched.
+// An update without operators replaces the whole document (keeping the _id).
+// Supported operators: $set, $unset, $inc, $push, $addToSet (both with $each) and $pull.
+func Update(doc map[string]interface{}, upd map[string]interface{}) (map[string]interface{}, error) {
+	if !isUpdateOperators(upd) {
+		ret := CopyMap(upd)
+		if id, has := doc["_id"]; has {
+			ret["_id"] = id
+		}
+		return ret, nil
+	}
+	ret := CopyMap(doc)
+	for op, arg := range upd {
+		// Modules do {"$unset": "fieldname"} too.
+		if op == "$unset" {
+			if s, ok := arg.(string); ok {
+				unset(ret, s)
+				continue
+			}
+		}
+		fields, ok := toMap(arg)
+		if !ok {
+			return nil, fmt.Errorf("Argument of %v must be a map.", op)
+		}
+		for path, v := range fields {
+			var err error
+			switch op {
+			case "$set":
+				if path == "_id" {
+					if !Equal(ret["_id"], v) {
+						return nil, fmt.Errorf("Can't modify _id.")
+					}
+					continue
+				}
+				err = set(ret, path, Copy(v))
+			case "$unset":
+				unset(ret, path)
+			case "$inc":
+				cur, _ := Get(ret, path)
+				if cur == nil {
+					cur = 0
+				}
+				ci, cur_int := isInt(cur)
+				vi, v_int := isInt(v)
+				if cur_int && v_int {
+					err = set(ret, path, ci+vi)
+					break
+				}
+				cf, cur_num := isNum(cur)
+				vf, v_num := isNum(v)
+				if !cur_num || !v_num {
+					return nil, fmt.Errorf("Can't $inc non numeric field %v.", path)
+				}
+				err = set(ret, path, cf+vf)
+			case "$push", "$addToSet":
+				var sl []interface{}
+				sl, err = fieldArray(ret, path, op)
+				if err != nil {
+					return nil, err
+				}
+				for _, x := range each(v) {
+					if op == "$addToSet" {
+						contains := false
+						for _, y := range sl {
+							if Equal(x, y) {
+								contains = true
+								break
+							}
+						}
+						if contains {
+							continue
+						}
+					}
+					sl = append(sl, Copy(x))
+				}
+				err = set(ret, path, sl)
+			case "$pull":
+				var sl []interface{}
+				sl, err = fieldArray(ret, path, op)
+				if err != nil {
+					return nil, err
+				}
+				kept := []interface{}{}
+				for _, x := range sl {
+					var m bool
+					if ops, is_ops := IsOperatorMap(v); is_ops {
+						m, err = matchOperators(x, true, ops)
+					} else {
+						m, err = matchValue(x, true, v)
+					}
+					if err != nil {
+						return nil, err
+					}
+					if !m {
+						kept = append(kept, x)
+					}
+				}
+				err = set(ret, path, kept)
+			default:
+				return nil, fmt.Errorf("Unknown update operator %v.", op)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Sorts docs by keys, a key prefixed with "-" means descending order, eg. []string{"-created", "title"}.
+func Sort(docs []map[string]interface{}, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, k := range keys {
+			desc := strings.HasPrefix(k, "-")
+			field := strings.TrimLeft(k, "-+")
+			a, _ := Get(docs[i], field)
+			b, _ := Get(docs[j], field)
+			c := Compare(a, b)
+			if c == 0 {
+				continue
+			}
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// Fields the Sets never return, whatever the projection is.
+var Hidden = []string{"password"}
+
+func inclusive(sel map[string]int) bool {
+	for i, v := range sel {
+		if v != 0 && i != "_id" {
+			return true
+		}
+	}
+	return false
+}
+
+// Selector completes the projection sel with the exclusion of the Hidden fields.
+// Like with MongoDB, a projection either lists the fields to return (1) or the ones to leave out (0), only the _id can be excluded
+// from an inclusive one.
+func Selector(sel map[string]int) map[string]int {
+	ret := map[string]int{}
+	for i, v := range sel {
+		ret[i] = v
+	}
+	incl := inclusive(sel)
+	for _, v := range Hidden {
+		if incl {
+			delete(ret, v)
+		} else {
+			ret[v] = 0
+		}
+	}
+	if incl && !inclusive(ret) {
+		ret["_id"] = 1		// Only hidden fields were asked for, an empty projection would return everything.
+	}
+	return ret
+}
+
+// Project returns doc projected by sel, see Selector. doc is modified in place if sel is exclusive.
+func Project(doc map[string]interface{}, sel map[string]int) map[string]interface{} {
+	sel = Selector(sel)
+	if !inclusive(sel) {
+		for i := range sel {
+			unset(doc, i)
+		}
+		return doc
+	}
+	ret := map[string]interface{}{}
+	if v, has := sel["_id"]; !has || v != 0 {
+		if id, has := doc["_id"]; has {
+			ret["_id"] = id
+		}
+	}
+	for i, v := range sel {
+		if v == 0 || i == "_id" {
+			continue
+		}
+		if val, has := Get(doc, i); has {
+			set(ret, i, val)
+		}
+	}
+	return ret
+}
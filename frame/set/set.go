@@ -1,13 +1,49 @@
 package set
 
 import(
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/indexes"
 	"github.com/opesun/chill/frame/misc/convert"
 	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/query"
 	"labix.org/v2/mgo"
+	"regexp"
+	"strings"
+	"time"
 )
 
+// Gives a kind to the errors mgo returns when nothing matches the query.
+func notFound(err error) error {
+	if err == mgo.ErrNotFound {
+		return errs.Wrap(errs.NotFound, err)
+	}
+	return err
+}
+
+// Eg. "E11000 duplicate key error index: chill.users.$chill_slug_1  dup key: { : "john" }".
+var dup_index = regexp.MustCompile(`index: (?:\S*\.\$)?(\S+)\s+dup key`)
+
+// Translates the unique index violations into field level errors.
+func (s *Set) dupErr(err error) error {
+	if err == nil || (!strings.Contains(err.Error(), "E11000") && !strings.Contains(err.Error(), "E11001")) {
+		return err
+	}
+	m := dup_index.FindStringSubmatch(err.Error())
+	if m == nil {
+		return errs.Wrap(errs.Conflict, err)
+	}
+	if m[1] == "_id_" {
+		return errs.AlreadyTaken("_id")
+	}
+	idxs, ierr := s.Indexes()
+	if ierr != nil {
+		return errs.Wrap(errs.Conflict, err)
+	}
+	return indexes.TakenByName(idxs, m[1])
+}
+
 func New(db *mgo.Database, coll string) iface.Set {
-	return &Set{db, coll, 0, 0, nil}
+	return &Set{db, coll, 0, 0, nil, nil}
 }
 
 type Set struct {
@@ -16,6 +52,7 @@ type Set struct {
 	skip	int
 	limit	int
 	sort	[]string
+	sel		map[string]int
 }
 
 func (s *Set) Skip(i int) {
@@ -30,11 +67,17 @@ func (s *Set) Sort(str ...string) {
 	s.sort = str
 }
 
+// Select sets the projection of the results, eg. {"title": 1, "slug": 1} or {"body": 0}. The hidden fields are always left out,
+// see query.Hidden.
+func (s *Set) Select(sel map[string]int) {
+	s.sel = sel
+}
+
 func (s *Set) FindOne(q map[string]interface{}) (map[string]interface{}, error) {
 	var res interface{}
-	err := s.db.C(s.coll).Find(q).One(&res)
+	err := s.db.C(s.coll).Find(q).Select(query.Selector(s.sel)).One(&res)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return convert.Clean(res).(map[string]interface{}), nil
 }
@@ -44,7 +87,7 @@ func (s *Set) Count(q map[string]interface{}) (int, error) {
 }
 
 func (s *Set) Find(q map[string]interface{}) ([]interface{}, error) {
-	c := s.db.C(s.coll).Find(q)
+	c := s.db.C(s.coll).Find(q).Select(query.Selector(s.sel))
 	if s.skip != 0 {
 		c.Skip(s.skip)
 	}
@@ -62,21 +105,93 @@ func (s *Set) Find(q map[string]interface{}) ([]interface{}, error) {
 	return convert.Clean(res).([]interface{}), nil
 }
 
+type cursor struct {
+	iter	*mgo.Iter
+}
+
+func (c *cursor) Next() (map[string]interface{}, bool) {
+	var res interface{}
+	if !c.iter.Next(&res) {
+		return nil, false
+	}
+	doc, _ := convert.Clean(res).(map[string]interface{})
+	return doc, true
+}
+
+func (c *cursor) Err() error {
+	return c.iter.Err()
+}
+
+func (c *cursor) Close() error {
+	return c.iter.Close()
+}
+
+// Iter is like Find, but the documents are fetched from the database batch documents at a time while iterating.
+func (s *Set) Iter(q map[string]interface{}, batch int) (iface.Cursor, error) {
+	c := s.db.C(s.coll).Find(q).Select(query.Selector(s.sel))
+	if s.skip != 0 {
+		c.Skip(s.skip)
+	}
+	if s.limit != 0 {
+		c.Limit(s.limit)
+	}
+	if len(s.sort) > 0 {
+		c.Sort(s.sort...)
+	}
+	if batch > 0 {
+		c.Batch(batch)
+	}
+	return &cursor{c.Iter()}, nil
+}
+
+// Aggregate runs agg as an aggregation pipeline (see pipeline), so the documents are grouped by the database.
+// The rows are the same as the ones of query.Aggregator, except that sum and avg ignore the array values of the fields,
+// and min and max compare them as a whole.
+// Skip, Limit, Sort and Select are not used.
+func (s *Set) Aggregate(q map[string]interface{}, agg iface.Aggregation) ([]map[string]interface{}, error) {
+	a, err := query.NewAggregator(agg)
+	if err != nil {
+		return nil, errs.New(errs.BadInput, "%v", err)
+	}
+	if q == nil {
+		q = map[string]interface{}{}
+	}
+	iter := s.db.C(s.coll).Pipe(pipeline(q, agg)).Iter()
+	ret := []map[string]interface{}{}
+	var res interface{}
+	for iter.Next(&res) {
+		if group, ok := convert.Clean(res).(map[string]interface{}); ok {
+			ret = append(ret, aggRow(group, agg))
+		}
+		res = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 && len(agg.By) == 0 {
+		return a.Result(), nil		// The single row of an empty set.
+	}
+	return ret, nil
+}
+
 func (s *Set) Insert(d map[string]interface{}) error {
-	return s.db.C(s.coll).Insert(d)
+	return s.dupErr(s.db.C(s.coll).Insert(d))
 }
 
 func (s *Set) Update(q map[string]interface{}, upd_query map[string]interface{}) error {
-	return s.db.C(s.coll).Update(q, upd_query)
+	return s.dupErr(notFound(s.db.C(s.coll).Update(q, upd_query)))
 }
 
 func (s *Set) UpdateAll(q map[string]interface{}, upd_query map[string]interface{}) (int, error) {
 	chi, err := s.db.C(s.coll).UpdateAll(q, upd_query)
-	return chi.Updated, err
+	if err != nil {
+		return 0, s.dupErr(err)
+	}
+	return chi.Updated, nil
 }
 
 func (s *Set) Remove(q map[string]interface{}) error {
-	return s.db.C(s.coll).Remove(q)
+	return notFound(s.db.C(s.coll).Remove(q))
 }
 
 func (s *Set) RemoveAll(q map[string]interface{}) (int, error) {
@@ -88,3 +203,47 @@ func (s *Set) Name() string {
 	return s.coll
 }
 
+func (s *Set) Indexes() ([]iface.Index, error) {
+	idxs, err := s.db.C(s.coll).Indexes()
+	if err != nil {
+		return nil, err
+	}
+	ret := []iface.Index{}
+	for _, v := range idxs {
+		ret = append(ret, iface.Index{
+			Name:	v.Name,
+			Key:	v.Key,
+			Unique:	v.Unique,
+			Sparse:	v.Sparse,
+			TTL:	int(v.ExpireAfter / time.Second),
+		})
+	}
+	return ret, nil
+}
+
+// TTL indexes work only on fields holding dates (time.Time values), not on unix timestamps.
+func (s *Set) EnsureIndex(idx iface.Index) error {
+	if idx.Name == "" {
+		idx.Name = indexes.Name(idx)
+	}
+	return s.db.C(s.coll).EnsureIndex(mgo.Index{
+		Name:			idx.Name,
+		Key:			idx.Key,
+		Unique:			idx.Unique,
+		Sparse:			idx.Sparse,
+		ExpireAfter:	time.Duration(idx.TTL) * time.Second,
+	})
+}
+
+func (s *Set) DropIndex(name string) error {
+	idxs, err := s.Indexes()
+	if err != nil {
+		return err
+	}
+	for _, v := range idxs {
+		if v.Name == name {
+			return s.db.C(s.coll).DropIndex(v.Key...)
+		}
+	}
+	return errs.New(errs.NotFound, "Index %v not found.", name)
+}
@@ -0,0 +1,228 @@
+package sqlset
+
+import(
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/indexes"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The declarations of the indexes are kept in this table, since they can't be recovered from the SQL indexes themselves.
+const meta_table = `"_indexes"`
+
+var valid_index = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+
+var unique_index = regexp.MustCompile(`UNIQUE constraint failed: index '([^']+)'`)
+
+var metas = struct{
+	sync.Mutex
+	m	map[*sql.DB]bool
+}{m: map[*sql.DB]bool{}}
+
+func ensureMeta(db *sql.DB) error {
+	metas.Lock()
+	defer metas.Unlock()
+	if metas.m[db] {
+		return nil
+	}
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + meta_table + " (coll TEXT NOT NULL, name TEXT NOT NULL, spec TEXT NOT NULL, PRIMARY KEY (coll, name))")
+	if err != nil {
+		return err
+	}
+	metas.m[db] = true
+	return nil
+}
+
+// TTL indexes of the collections, and the time the expired documents were last removed.
+var expiry = struct{
+	sync.Mutex
+	ttls	map[string][]iface.Index
+	last	map[string]time.Time
+}{ttls: map[string][]iface.Index{}, last: map[string]time.Time{}}
+
+func (s *Set) key() string {
+	return fmt.Sprintf("%p/%v", s.db, s.coll)
+}
+
+func forgetTTLs(key string) {
+	expiry.Lock()
+	defer expiry.Unlock()
+	delete(expiry.ttls, key)
+}
+
+// SQL index names are unique in the whole database, not just in a table.
+func (s *Set) indexName(name string) string {
+	return `"` + s.coll + "__" + name + `"`
+}
+
+// json_extract with a literal path, bound parameters are not allowed in index expressions.
+func extract(field string) string {
+	return "json_extract(doc, '" + strings.Replace(jsonPath(field), "'", "''", -1) + "')"
+}
+
+func (s *Set) Indexes() ([]iface.Index, error) {
+	if _, err := s.table(); err != nil {
+		return nil, err
+	}
+	if err := ensureMeta(s.db); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT spec FROM " + meta_table + " WHERE coll = ? ORDER BY name", s.coll)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []iface.Index{}
+	for rows.Next() {
+		var spec string
+		if err := rows.Scan(&spec); err != nil {
+			return nil, err
+		}
+		idx := iface.Index{}
+		if err := json.Unmarshal([]byte(spec), &idx); err != nil {
+			return nil, err
+		}
+		ret = append(ret, idx)
+	}
+	return ret, rows.Err()
+}
+
+// Unlike MongoDB, SQLite treats the missing values as distinct ones, so a unique index which is not sparse
+// still allows more than one document without the indexed fields.
+// Indexes are used to enforce the constraints only, the queries built by this package can't make use of them.
+func (s *Set) EnsureIndex(idx iface.Index) error {
+	if idx.Name == "" {
+		idx.Name = indexes.Name(idx)
+	}
+	if !valid_index.MatchString(idx.Name) {
+		return fmt.Errorf("Invalid index name %v.", idx.Name)
+	}
+	t, err := s.table()
+	if err != nil {
+		return err
+	}
+	if err := ensureMeta(s.db); err != nil {
+		return err
+	}
+	exprs := []string{}
+	present := []string{}
+	for _, v := range idx.Key {
+		e := extract(indexes.Field(v))
+		present = append(present, "json_type(doc, '" + strings.Replace(jsonPath(indexes.Field(v)), "'", "''", -1) + "') IS NOT NULL")
+		if strings.HasPrefix(v, "-") {
+			e += " DESC"
+		}
+		exprs = append(exprs, e)
+	}
+	stmt := "CREATE "
+	if idx.Unique {
+		stmt += "UNIQUE "
+	}
+	stmt += "INDEX " + s.indexName(idx.Name) + " ON " + t + " (" + strings.Join(exprs, ", ") + ")"
+	if idx.Sparse {
+		stmt += " WHERE " + strings.Join(present, " OR ")
+	}
+	spec, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	defer forgetTTLs(s.key())
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP INDEX IF EXISTS " + s.indexName(idx.Name))
+	if err == nil {
+		_, err = tx.Exec(stmt)
+		if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+			err = errs.New(errs.Conflict, "Collection %v has duplicates for the unique index %v.", s.coll, idx.Name)
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec("INSERT OR REPLACE INTO " + meta_table + " (coll, name, spec) VALUES (?, ?, ?)", s.coll, idx.Name, string(spec))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Set) DropIndex(name string) error {
+	if !valid_index.MatchString(name) {
+		return fmt.Errorf("Invalid index name %v.", name)
+	}
+	if err := ensureMeta(s.db); err != nil {
+		return err
+	}
+	defer forgetTTLs(s.key())
+	res, err := s.db.Exec("DELETE FROM " + meta_table + " WHERE coll = ? AND name = ?", s.coll, name)
+	if err != nil {
+		return err
+	}
+	if c, _ := res.RowsAffected(); c == 0 {
+		return errs.New(errs.NotFound, "Index %v not found.", name)
+	}
+	_, err = s.db.Exec("DROP INDEX IF EXISTS " + s.indexName(name))
+	return err
+}
+
+// Translates the unique constraint violations into field level errors.
+func (s *Set) dupErr(err error) error {
+	if err == nil || !strings.Contains(err.Error(), "UNIQUE") {
+		return err
+	}
+	if m := unique_index.FindStringSubmatch(err.Error()); m != nil {
+		idxs, ierr := s.Indexes()
+		if ierr != nil {
+			return ierr
+		}
+		return indexes.TakenByName(idxs, strings.TrimPrefix(m[1], s.coll + "__"))
+	}
+	return errs.AlreadyTaken("_id")
+}
+
+// Removes the documents expired according to the TTL indexes, at most once a second per collection.
+// Only unix timestamps are understood, since time.Time values are stored as strings.
+func (s *Set) expire() error {
+	key := s.key()
+	expiry.Lock()
+	defer expiry.Unlock()
+	ttls, known := expiry.ttls[key]
+	if !known {
+		idxs, err := s.Indexes()
+		if err != nil {
+			return err
+		}
+		ttls = []iface.Index{}
+		for _, v := range idxs {
+			if v.TTL > 0 {
+				ttls = append(ttls, v)
+			}
+		}
+		expiry.ttls[key] = ttls
+	}
+	now := time.Now()
+	if len(ttls) == 0 || now.Sub(expiry.last[key]) < time.Second {
+		return nil
+	}
+	expiry.last[key] = now
+	t, err := s.table()
+	if err != nil {
+		return err
+	}
+	for _, v := range ttls {
+		p := jsonPath(indexes.Field(v.Key[0]))
+		_, err := s.db.Exec("DELETE FROM " + t + " WHERE json_type(doc, ?) IN ('integer', 'real') AND json_extract(doc, ?) <= ?", p, p, now.Unix() - int64(v.TTL))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
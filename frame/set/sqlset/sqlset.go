@@ -0,0 +1,807 @@
+// Package sqlset implements iface.Set on top of an embedded SQLite database.
+// Every collection is a table of JSON encoded documents, the MongoDB style query maps built by the filter package are translated
+// into SQL using the JSON functions of SQLite.
+package sqlset
+
+import(
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/opesun/chill/frame/errs"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/query"
+	"labix.org/v2/mgo/bson"
+	_ "github.com/mattn/go-sqlite3"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// ObjectIds are stored as strings with this prefix, since JSON has no such type.
+	oid_prefix = "$oid:"
+)
+
+var ErrNotFound = errs.New(errs.NotFound, "Not found.")
+
+// Open opens the database file at path. The writers wait for each other instead of failing with "database is locked",
+// and the readers don't block the writer (WAL journal). The connections are limited to one, SQLite serializes the writes
+// anyway, and so a transaction never waits for an other connection of the process.
+// The documents are queried with the JSON1 functions, Open fails if SQLite was built without them (go-sqlite3 has them
+// built in since 1.14, older releases need the sqlite_json build tag).
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path + "?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	var probe string
+	if err := db.QueryRow("SELECT json('{}')").Scan(&probe); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("SQLite has no JSON1 support: %v", err)
+	}
+	return db, nil
+}
+
+func New(db *sql.DB, coll string) iface.Set {
+	return &Set{db, coll, 0, 0, nil, nil}
+}
+
+type Set struct {
+	db 		*sql.DB
+	coll 	string
+	skip	int
+	limit	int
+	sort	[]string
+	sel		map[string]int
+}
+
+func (s *Set) Skip(i int) {
+	s.skip = i
+}
+
+func (s *Set) Limit(i int) {
+	s.limit = i
+}
+
+func (s *Set) Sort(str ...string) {
+	s.sort = str
+}
+
+// Select sets the projection of the results, eg. {"title": 1, "slug": 1} or {"body": 0}. The hidden fields are always left out,
+// see query.Hidden.
+func (s *Set) Select(sel map[string]int) {
+	s.sel = sel
+}
+
+func (s *Set) Name() string {
+	return s.coll
+}
+
+var valid_coll = regexp.MustCompile("^[a-zA-Z0-9_.]+$")		// The dot separates the site prefixes, see package sites.
+
+var created = struct{
+	sync.Mutex
+	m	map[string]bool
+}{m: map[string]bool{}}
+
+// Returns the quoted table name of the collection, creating the table if it does not exist yet.
+func (s *Set) table() (string, error) {
+	if !valid_coll.MatchString(s.coll) {
+		return "", fmt.Errorf("Invalid collection name %v.", s.coll)
+	}
+	t := `"` + s.coll + `"`
+	key := fmt.Sprintf("%p/%v", s.db, s.coll)
+	created.Lock()
+	defer created.Unlock()
+	if created.m[key] {
+		return t, nil
+	}
+	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS " + t + " (id TEXT PRIMARY KEY, doc TEXT NOT NULL)")
+	if err != nil {
+		return "", err
+	}
+	created.m[key] = true
+	return t, nil
+}
+
+// Converts a document to its storable form.
+func encode(v interface{}) interface{} {
+	switch val := v.(type) {
+	case bson.ObjectId:
+		return oid_prefix + val.Hex()
+	case map[string]interface{}:
+		ret := map[string]interface{}{}
+		for i, x := range val {
+			ret[i] = encode(x)
+		}
+		return ret
+	case bson.M:
+		return encode(map[string]interface{}(val))
+	}
+	if sl, ok := query.ToSlice(v); ok {
+		ret := []interface{}{}
+		for _, x := range sl {
+			ret = append(ret, encode(x))
+		}
+		return ret
+	}
+	return v
+}
+
+// Converts a stored document back.
+func decode(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		if strings.HasPrefix(val, oid_prefix) && bson.IsObjectIdHex(val[len(oid_prefix):]) {
+			return bson.ObjectIdHex(val[len(oid_prefix):])
+		}
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		for i, x := range val {
+			val[i] = decode(x)
+		}
+	case []interface{}:
+		for i, x := range val {
+			val[i] = decode(x)
+		}
+	}
+	return v
+}
+
+func marshal(v interface{}) (string, error) {
+	b, err := json.Marshal(encode(v))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func unmarshal(s string) (map[string]interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v map[string]interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return decode(v).(map[string]interface{}), nil
+}
+
+// "author.name" => `$."author"."name"`
+func jsonPath(field string) string {
+	p := strings.Split(field, ".")
+	for i, v := range p {
+		p[i] = `"` + strings.Replace(v, `"`, `\"`, -1) + `"`
+	}
+	return "$." + strings.Join(p, ".")
+}
+
+// Builds the SQL WHERE clause from query maps.
+type where struct {
+	args	[]interface{}
+}
+
+func (w *where) arg(v interface{}) string {
+	w.args = append(w.args, v)
+	return "?"
+}
+
+func isNum(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+	return false
+}
+
+// Condition on a row of json_each (aliased je), comparing it to v with the op SQL operator.
+func (w *where) valueCond(v interface{}, op string) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return "(je.type = 'text' AND je.value " + op + " " + w.arg(val) + ")", nil
+	case bson.ObjectId:
+		return "(je.type = 'text' AND je.value " + op + " " + w.arg(encode(val)) + ")", nil
+	case bool:
+		if op != "=" {
+			return "", fmt.Errorf("Can't compare booleans with %v.", op)
+		}
+		if val {
+			return "je.type = 'true'", nil
+		}
+		return "je.type = 'false'", nil
+	}
+	if isNum(v) {
+		return "(je.type IN ('integer', 'real') AND je.value " + op + " " + w.arg(v) + ")", nil
+	}
+	if op != "=" {
+		return "", fmt.Errorf("Can't compare %v with %v.", v, op)
+	}
+	enc, err := marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return "(je.type IN ('object', 'array') AND je.value = json(" + w.arg(enc) + "))", nil
+}
+
+// Matches like MongoDB does: the field itself or any of its elements if it is an array. Only arrays are walked by json_each,
+// it would list the members of an object too.
+// The condition is built by cond after the path arguments are in place, so the arguments follow the order of the placeholders.
+func (w *where) each(field string, cond func() (string, error)) (string, error) {
+	p := jsonPath(field)
+	from := "(SELECT type, value FROM json_each(doc, " + w.arg(p) + ") WHERE json_type(doc, " + w.arg(p) + ") = 'array'" +
+		" UNION ALL SELECT json_type(doc, " + w.arg(p) + "), json_extract(doc, " + w.arg(p) + ") WHERE json_type(doc, " + w.arg(p) + ") != 'array')"
+	c, err := cond()
+	if err != nil {
+		return "", err
+	}
+	return "EXISTS (SELECT 1 FROM " + from + " AS je WHERE " + c + ")", nil
+}
+
+func (w *where) isNull(field string) string {
+	p := jsonPath(field)
+	return "(json_type(doc, " + w.arg(p) + ") IS NULL OR json_type(doc, " + w.arg(p) + ") = 'null')"
+}
+
+func (w *where) eq(field string, v interface{}) (string, error) {
+	if v == nil {
+		return w.isNull(field), nil
+	}
+	if re, is_re := v.(bson.RegEx); is_re {
+		return w.regex(field, re.Pattern, re.Options)
+	}
+	if _, is_slice := query.ToSlice(v); is_slice {
+		enc, err := marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return "json_extract(doc, " + w.arg(jsonPath(field)) + ") = json(" + w.arg(enc) + ")", nil
+	}
+	return w.each(field, func() (string, error) {
+		return w.valueCond(v, "=")
+	})
+}
+
+const regex_meta = `\.+*?()|[]{}^$`
+
+// The literal prefix matched by pattern, eg. "a.b" for "^a\.b". The metacharacters can only appear escaped, like
+// regexp.QuoteMeta does it (see the prefix operator of package filter).
+func regexPrefix(pattern string) (string, bool) {
+	if !strings.HasPrefix(pattern, "^") {
+		return "", false
+	}
+	ret := []rune{}
+	escaped := false
+	for _, v := range pattern[1:] {
+		meta := strings.ContainsRune(regex_meta, v)
+		switch {
+		case escaped && !meta:
+			return "", false
+		case escaped:
+			escaped = false
+		case v == '\\':
+			escaped = true
+			continue
+		case meta:
+			return "", false
+		}
+		ret = append(ret, v)
+	}
+	if escaped {
+		return "", false
+	}
+	return string(ret), true
+}
+
+// Only prefix regexes (like the ones generated by the fulltext module) are supported, eg. "^abc".
+func (w *where) regex(field, pattern, options string) (string, error) {
+	prefix, ok := regexPrefix(pattern)
+	if !ok {
+		return "", fmt.Errorf("Only prefix regexes are supported by the SQLite backend, got %v.", pattern)
+	}
+	return w.each(field, func() (string, error) {
+		if strings.Contains(options, "i") {
+			return "(je.type = 'text' AND lower(substr(je.value, 1, " + w.arg(utf8.RuneCountInString(prefix)) + ")) = lower(" + w.arg(prefix) + "))", nil
+		}
+		return "(je.type = 'text' AND substr(je.value, 1, " + w.arg(utf8.RuneCountInString(prefix)) + ") = " + w.arg(prefix) + ")", nil
+	})
+}
+
+func join(conds []string, sep, empty string) string {
+	if len(conds) == 0 {
+		return empty
+	}
+	return "(" + strings.Join(conds, sep) + ")"
+}
+
+func (w *where) operators(field string, ops map[string]interface{}) (string, error) {
+	conds := []string{}
+	for op, arg := range ops {
+		var cond string
+		var err error
+		switch op {
+		case "$eq":
+			cond, err = w.eq(field, arg)
+		case "$ne":
+			cond, err = w.eq(field, arg)
+			cond = "NOT " + cond
+		case "$in", "$nin", "$all":
+			vals, ok := query.ToSlice(arg)
+			if !ok {
+				return "", fmt.Errorf("Argument of %v must be an array.", op)
+			}
+			sub := []string{}
+			for _, v := range vals {
+				c, err := w.eq(field, v)
+				if err != nil {
+					return "", err
+				}
+				sub = append(sub, c)
+			}
+			switch op {
+			case "$in":
+				cond = join(sub, " OR ", "0")
+			case "$nin":
+				cond = "NOT " + join(sub, " OR ", "0")
+			case "$all":
+				cond = join(sub, " AND ", "0")
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			sqlop := map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}[op]
+			cond, err = w.each(field, func() (string, error) {
+				return w.valueCond(arg, sqlop)
+			})
+		case "$exists":
+			want, _ := arg.(bool)
+			if want {
+				cond = "json_type(doc, " + w.arg(jsonPath(field)) + ") IS NOT NULL"
+			} else {
+				cond = "json_type(doc, " + w.arg(jsonPath(field)) + ") IS NULL"
+			}
+		case "$regex":
+			pattern, ok := arg.(string)
+			if !ok {
+				return "", fmt.Errorf("Argument of $regex must be a string.")
+			}
+			options, _ := ops["$options"].(string)
+			cond, err = w.regex(field, pattern, options)
+		case "$options":
+			continue
+		case "$size":
+			cond = "json_array_length(doc, " + w.arg(jsonPath(field)) + ") = " + w.arg(arg)
+		default:
+			return "", fmt.Errorf("Query operator %v is not supported by the SQLite backend.", op)
+		}
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, cond)
+	}
+	return join(conds, " AND ", "1"), nil
+}
+
+func (w *where) query(q map[string]interface{}) (string, error) {
+	conds := []string{}
+	for key, val := range q {
+		var cond string
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			subs, ok := query.ToSlice(val)
+			if !ok {
+				return "", fmt.Errorf("Argument of %v must be an array.", key)
+			}
+			sub := []string{}
+			for _, v := range subs {
+				m, ok := v.(map[string]interface{})
+				if !ok {
+					return "", fmt.Errorf("Elements of %v must be maps.", key)
+				}
+				c, err := w.query(m)
+				if err != nil {
+					return "", err
+				}
+				sub = append(sub, c)
+			}
+			switch key {
+			case "$and":
+				cond = join(sub, " AND ", "1")
+			case "$or":
+				cond = join(sub, " OR ", "0")
+			case "$nor":
+				cond = "NOT " + join(sub, " OR ", "0")
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return "", fmt.Errorf("Query operator %v is not supported by the SQLite backend.", key)
+			}
+			if ops, is_ops := query.IsOperatorMap(val); is_ops {
+				cond, err = w.operators(key, ops)
+			} else {
+				cond, err = w.eq(key, val)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, cond)
+	}
+	return join(conds, " AND ", "1"), nil
+}
+
+// Returns the table name, the WHERE clause and its arguments.
+func (s *Set) where(q map[string]interface{}) (string, string, []interface{}, error) {
+	t, err := s.table()
+	if err != nil {
+		return "", "", nil, err
+	}
+	w := &where{}
+	cond, err := w.query(q)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return t, cond, w.args, nil
+}
+
+func (s *Set) orderBy() (string, []interface{}) {
+	if len(s.sort) == 0 {
+		return "", nil
+	}
+	parts := []string{}
+	args := []interface{}{}
+	for _, v := range s.sort {
+		dir := "ASC"
+		if strings.HasPrefix(v, "-") {
+			dir = "DESC"
+		}
+		parts = append(parts, "json_extract(doc, ?) " + dir)
+		args = append(args, jsonPath(strings.TrimLeft(v, "-+")))
+	}
+	return " ORDER BY " + strings.Join(parts, ", "), args
+}
+
+func scanDocs(rows *sql.Rows) ([]map[string]interface{}, []string, error) {
+	defer rows.Close()
+	docs := []map[string]interface{}{}
+	ids := []string{}
+	for rows.Next() {
+		var id, doc string
+		err := rows.Scan(&id, &doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		d, err := unmarshal(doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, d)
+		ids = append(ids, id)
+	}
+	return docs, ids, rows.Err()
+}
+
+func (s *Set) FindOne(q map[string]interface{}) (map[string]interface{}, error) {
+	if err := s.expire(); err != nil {
+		return nil, err
+	}
+	t, cond, args, err := s.where(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT id, doc FROM " + t + " WHERE " + cond + " LIMIT 1", args...)
+	if err != nil {
+		return nil, err
+	}
+	docs, _, err := scanDocs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, ErrNotFound
+	}
+	return query.Project(docs[0], s.sel), nil
+}
+
+func (s *Set) Count(q map[string]interface{}) (int, error) {
+	if err := s.expire(); err != nil {
+		return 0, err
+	}
+	t, cond, args, err := s.where(q)
+	if err != nil {
+		return 0, err
+	}
+	var c int
+	err = s.db.QueryRow("SELECT COUNT(*) FROM " + t + " WHERE " + cond, args...).Scan(&c)
+	return c, err
+}
+
+// Runs the query of Find and Iter.
+func (s *Set) selectRows(q map[string]interface{}) (*sql.Rows, error) {
+	if err := s.expire(); err != nil {
+		return nil, err
+	}
+	t, cond, args, err := s.where(q)
+	if err != nil {
+		return nil, err
+	}
+	order, oargs := s.orderBy()
+	args = append(args, oargs...)
+	stmt := "SELECT id, doc FROM " + t + " WHERE " + cond + order
+	if s.limit != 0 || s.skip != 0 {
+		limit := s.limit
+		if limit == 0 {
+			limit = -1
+		}
+		stmt += " LIMIT ? OFFSET ?"
+		args = append(args, limit, s.skip)
+	}
+	return s.db.Query(stmt, args...)
+}
+
+func (s *Set) Find(q map[string]interface{}) ([]interface{}, error) {
+	rows, err := s.selectRows(q)
+	if err != nil {
+		return nil, err
+	}
+	docs, _, err := scanDocs(rows)
+	if err != nil {
+		return nil, err
+	}
+	ret := []interface{}{}
+	for _, v := range docs {
+		ret = append(ret, query.Project(v, s.sel))
+	}
+	return ret, nil
+}
+
+type cursor struct {
+	rows	*sql.Rows
+	sel		map[string]int
+	err		error
+}
+
+func (c *cursor) Next() (map[string]interface{}, bool) {
+	if c.err != nil || !c.rows.Next() {
+		return nil, false
+	}
+	var id, doc string
+	if c.err = c.rows.Scan(&id, &doc); c.err != nil {
+		return nil, false
+	}
+	d, err := unmarshal(doc)
+	if err != nil {
+		c.err = err
+		return nil, false
+	}
+	return query.Project(d, c.sel), true
+}
+
+func (c *cursor) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.rows.Err()
+}
+
+func (c *cursor) Close() error {
+	return c.rows.Close()
+}
+
+// Iter is like Find, but the rows are read one by one while iterating. The rows are streamed by SQLite anyway, batch is not used.
+func (s *Set) Iter(q map[string]interface{}, batch int) (iface.Cursor, error) {
+	rows, err := s.selectRows(q)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor{rows: rows, sel: s.sel}, nil
+}
+
+// The documents are read one by one and aggregated by a query.Aggregator. Skip, Limit, Sort and Select are not used.
+func (s *Set) Aggregate(q map[string]interface{}, agg iface.Aggregation) ([]map[string]interface{}, error) {
+	a, err := query.NewAggregator(agg)
+	if err != nil {
+		return nil, errs.New(errs.BadInput, "%v", err)
+	}
+	if err := s.expire(); err != nil {
+		return nil, err
+	}
+	t, cond, args, err := s.where(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT doc FROM " + t + " WHERE " + cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var doc string
+		if err := rows.Scan(&doc); err != nil {
+			return nil, err
+		}
+		d, err := unmarshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		a.Add(d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return a.Result(), nil
+}
+
+func (s *Set) Insert(d map[string]interface{}) error {
+	t, err := s.table()
+	if err != nil {
+		return err
+	}
+	doc := query.CopyMap(d)
+	if _, has := doc["_id"]; !has {
+		doc["_id"] = bson.NewObjectId()
+	}
+	id, err := marshal(doc["_id"])
+	if err != nil {
+		return err
+	}
+	enc, err := marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT INTO " + t + " (id, doc) VALUES (?, ?)", id, enc)
+	return s.dupErr(err)
+}
+
+// Translates an update query consisting only of $set and $unset into a chain of json_set and json_remove calls.
+// Returns false if the update needs other operators.
+func setUnset(upd map[string]interface{}) (string, []interface{}, bool, error) {
+	expr := "doc"
+	args := []interface{}{}
+	for op, arg := range upd {
+		switch op {
+		case "$set":
+			m, ok := arg.(map[string]interface{})
+			if !ok {
+				return "", nil, false, fmt.Errorf("Argument of $set must be a map.")
+			}
+			for field, v := range m {
+				// json_set does not create the missing parent objects of nested fields.
+				if field == "_id" || strings.Contains(field, ".") {
+					return "", nil, false, nil
+				}
+				enc, err := marshal(v)
+				if err != nil {
+					return "", nil, false, err
+				}
+				expr = "json_set(" + expr + ", ?, json(?))"
+				args = append(args, jsonPath(field), enc)
+			}
+		case "$unset":
+			fields := []string{}
+			switch val := arg.(type) {
+			case string:
+				fields = append(fields, val)
+			case map[string]interface{}:
+				for field := range val {
+					fields = append(fields, field)
+				}
+			default:
+				return "", nil, false, fmt.Errorf("Argument of $unset must be a map.")
+			}
+			for _, field := range fields {
+				expr = "json_remove(" + expr + ", ?)"
+				args = append(args, jsonPath(field))
+			}
+		default:
+			return "", nil, false, nil
+		}
+	}
+	return expr, args, true, nil
+}
+
+func (s *Set) update(q map[string]interface{}, upd_query map[string]interface{}, max int) (int, error) {
+	t, cond, args, err := s.where(q)
+	if err != nil {
+		return 0, err
+	}
+	limit := ""
+	if max > 0 {
+		limit = fmt.Sprintf(" LIMIT %v", max)
+	}
+	expr, uargs, ok, err := setUnset(upd_query)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		all_args := append(uargs, args...)
+		res, err := s.db.Exec("UPDATE " + t + " SET doc = " + expr + " WHERE rowid IN (SELECT rowid FROM " + t + " WHERE " + cond + limit + ")", all_args...)
+		if err != nil {
+			return 0, s.dupErr(err)
+		}
+		c, err := res.RowsAffected()
+		return int(c), err
+	}
+	// Array operators and whole document replacements are applied to the matching rows one by one, in a transaction.
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query("SELECT id, doc FROM " + t + " WHERE " + cond + limit, args...)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	docs, ids, err := scanDocs(rows)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	for i, v := range docs {
+		doc, err := query.Update(v, upd_query)
+		if err == nil {
+			var enc string
+			enc, err = marshal(doc)
+			if err == nil {
+				_, err = tx.Exec("UPDATE " + t + " SET doc = ? WHERE id = ?", enc, ids[i])
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, s.dupErr(err)
+		}
+	}
+	return len(docs), tx.Commit()
+}
+
+func (s *Set) Update(q map[string]interface{}, upd_query map[string]interface{}) error {
+	c, err := s.update(q, upd_query, 1)
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Set) UpdateAll(q map[string]interface{}, upd_query map[string]interface{}) (int, error) {
+	return s.update(q, upd_query, 0)
+}
+
+func (s *Set) remove(q map[string]interface{}, max int) (int, error) {
+	t, cond, args, err := s.where(q)
+	if err != nil {
+		return 0, err
+	}
+	limit := ""
+	if max > 0 {
+		limit = fmt.Sprintf(" LIMIT %v", max)
+	}
+	res, err := s.db.Exec("DELETE FROM " + t + " WHERE rowid IN (SELECT rowid FROM " + t + " WHERE " + cond + limit + ")", args...)
+	if err != nil {
+		return 0, err
+	}
+	c, err := res.RowsAffected()
+	return int(c), err
+}
+
+func (s *Set) Remove(q map[string]interface{}) error {
+	c, err := s.remove(q, 1)
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Set) RemoveAll(q map[string]interface{}) (int, error) {
+	return s.remove(q, 0)
+}
@@ -0,0 +1,352 @@
+package sqlset_test
+
+import(
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/filter"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/sqlset"
+	"labix.org/v2/mgo/bson"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+type MockEvent struct {}
+
+func (m MockEvent) Fire(s string, params ...interface{}) {
+}
+
+func (m MockEvent) Iterate(s string, ret_rec interface{}, params ...interface{}) {
+}
+
+func cars(t *testing.T) *sqlset.Set {
+	db, err := sqlset.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	set := sqlset.New(db, "cars").(*sqlset.Set)
+	docs := []map[string]interface{}{
+		{"make": "bmw", "year": 2001, "tags": []interface{}{"fast", "german"}},
+		{"make": "audi", "year": 1999, "tags": []interface{}{"german"}},
+		{"make": "fiat", "year": 2010},
+	}
+	for _, v := range docs {
+		err := set.Insert(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return set
+}
+
+func TestFindSortSkipLimit(t *testing.T) {
+	set := cars(t)
+	set.Sort("-year")
+	set.Skip(1)
+	set.Limit(1)
+	docs, err := set.Find(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].(map[string]interface{})["make"] != "bmw" {
+		t.Fatal(docs)
+	}
+	if _, is_id := docs[0].(map[string]interface{})["_id"].(bson.ObjectId); !is_id {
+		t.Fatal(docs)
+	}
+	c, err := set.Count(nil)
+	if err != nil || c != 3 {
+		t.Fatal(c, err)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	set := cars(t)
+	queries := []struct{
+		q		map[string]interface{}
+		count	int
+	}{
+		{map[string]interface{}{"tags": "german"}, 2},
+		{map[string]interface{}{"make": map[string]interface{}{"$in": []interface{}{"bmw", "fiat"}}}, 2},
+		{map[string]interface{}{"$and": []interface{}{
+			map[string]interface{}{"make": bson.RegEx{Pattern: "^b"}},
+			map[string]interface{}{"tags": "fast"},
+		}}, 1},
+		{map[string]interface{}{"year": map[string]interface{}{"$gte": 2001}}, 2},
+		{map[string]interface{}{"tags": map[string]interface{}{"$exists": false}}, 1},
+		{map[string]interface{}{"tags": map[string]interface{}{"$all": []interface{}{"fast", "german"}}}, 1},
+		{map[string]interface{}{"tags": map[string]interface{}{"$all": []interface{}{}}}, 0},
+	}
+	for _, v := range queries {
+		c, err := set.Count(v.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != v.count {
+			t.Fatal(v.q, c)
+		}
+	}
+	_, err := set.Count(map[string]interface{}{"make": bson.RegEx{Pattern: "b.*w"}})
+	if err == nil {
+		t.Fatal()
+	}
+}
+
+// A value matches the elements of an array, but not the members of an object.
+func TestQueryNested(t *testing.T) {
+	set := cars(t)
+	docs := []map[string]interface{}{
+		{"make": "opel", "engine": 5},
+		{"make": "seat", "engine": map[string]interface{}{"size": 5}},
+		{"make": "skoda", "engine": []interface{}{4, 5}},
+		{"make": "dacia", "engine": map[string]interface{}{"size": 4}},
+	}
+	for _, v := range docs {
+		if err := set.Insert(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queries := []struct{
+		q		map[string]interface{}
+		count	int
+	}{
+		{map[string]interface{}{"engine": 5}, 2},
+		{map[string]interface{}{"engine": map[string]interface{}{"$gt": 4}}, 2},
+		{map[string]interface{}{"engine.size": 5}, 1},
+		{map[string]interface{}{"engine": map[string]interface{}{"size": 4}}, 1},
+		{map[string]interface{}{"engine": map[string]interface{}{"$in": []interface{}{4, 6}}}, 1},
+	}
+	for _, v := range queries {
+		c, err := set.Count(v.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != v.count {
+			t.Fatal(v.q, c)
+		}
+	}
+}
+
+// The prefixes quoted by regexp.QuoteMeta can be matched too.
+func TestQueryPrefix(t *testing.T) {
+	set := cars(t)
+	for _, v := range []string{"a.b", "axb", "a.b (2)"} {
+		if err := set.Insert(map[string]interface{}{"make": v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for prefix, count := range map[string]int{"a.b": 2, "a.b (": 1, "a": 4} {
+		c, err := set.Count(map[string]interface{}{"make": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix)}})
+		if err != nil || c != count {
+			t.Fatal(prefix, c, err)
+		}
+	}
+	for _, v := range []string{"^a.b", `^a\`, `^a\w`} {
+		if _, err := set.Count(map[string]interface{}{"make": bson.RegEx{Pattern: v}}); err == nil {
+			t.Fatal(v)
+		}
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	set := cars(t)
+	q := map[string]interface{}{"make": "bmw"}
+	upds := []map[string]interface{}{
+		{"$set": map[string]interface{}{"color": "red"}},
+		{"$addToSet": map[string]interface{}{"tags": map[string]interface{}{"$each": []interface{}{"fast", "red"}}}},
+		{"$pull": map[string]interface{}{"tags": "german"}},
+		{"$unset": map[string]interface{}{"year": 1}},
+	}
+	for _, v := range upds {
+		err := set.Update(q, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc, err := set.FindOne(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := doc["tags"].([]interface{})
+	if doc["color"] != "red" || len(tags) != 2 || tags[0] != "fast" || tags[1] != "red" || doc["year"] != nil {
+		t.Fatal(doc)
+	}
+	c, err := set.RemoveAll(map[string]interface{}{"tags": "german"})
+	if err != nil || c != 1 {
+		t.Fatal(c, err)
+	}
+}
+
+func TestIds(t *testing.T) {
+	set := cars(t)
+	id := bson.NewObjectId()
+	err := set.Insert(map[string]interface{}{"_id": id, "_cars": []bson.ObjectId{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Insert(map[string]interface{}{"_id": id})
+	if err == nil {
+		t.Fatal()
+	}
+	q := map[string]interface{}{"_cars": map[string]interface{}{"$in": []bson.ObjectId{id}}}
+	doc, err := set.FindOne(q)
+	if err != nil || doc["_id"] != id {
+		t.Fatal(doc, err)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	set := cars(t)
+	idx := iface.Index{Name: "chill_make_1", Key: []string{"make"}, Unique: true, Sparse: true}
+	err := set.EnsureIndex(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Insert(map[string]interface{}{"make": "bmw"})
+	if !errs.Is(err, errs.Conflict) || errs.FieldsOf(err)["make"] != "Already taken." {
+		t.Fatal(err)
+	}
+	err = set.Update(map[string]interface{}{"make": "fiat"}, map[string]interface{}{"$set": map[string]interface{}{"make": "audi"}})
+	if errs.FieldsOf(err)["make"] != "Already taken." {
+		t.Fatal(err)
+	}
+	for _, v := range []int{2000, 2002} {
+		err = set.Insert(map[string]interface{}{"year": v})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	idxs, err := set.Indexes()
+	if err != nil || len(idxs) != 1 || idxs[0].Name != idx.Name || !idxs[0].Unique {
+		t.Fatal(idxs, err)
+	}
+	err = set.EnsureIndex(iface.Index{Name: "chill_year_1", Key: []string{"year"}, Unique: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.EnsureIndex(iface.Index{Name: "chill_tags_1", Key: []string{"tags"}, Unique: true})
+	if err != nil {
+		t.Fatal(err)		// Distinct arrays.
+	}
+	err = set.Insert(map[string]interface{}{"make": "skoda", "year": 2001})
+	if errs.FieldsOf(err)["year"] != "Already taken." {
+		t.Fatal(err)
+	}
+	err = set.DropIndex(idx.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Insert(map[string]interface{}{"make": "bmw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errs.Is(set.DropIndex(idx.Name), errs.NotFound) {
+		t.Fatal()
+	}
+}
+
+func TestDuplicateId(t *testing.T) {
+	set := cars(t)
+	id := bson.NewObjectId()
+	err := set.Insert(map[string]interface{}{"_id": id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Insert(map[string]interface{}{"_id": id})
+	if errs.FieldsOf(err)["_id"] != "Already taken." {
+		t.Fatal(err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	set := cars(t)
+	err := set.Insert(map[string]interface{}{"make": "skoda", "password": "x", "engine": map[string]interface{}{"size": 1.2, "fuel": "diesel"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := map[string]interface{}{"make": "skoda"}
+	set.Select(map[string]int{"engine.fuel": 1, "_id": 0})
+	doc, err := set.FindOne(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 1 || doc["engine"].(map[string]interface{})["fuel"] != "diesel" || len(doc["engine"].(map[string]interface{})) != 1 {
+		t.Fatal(doc)
+	}
+	set.Select(nil)
+	docs, err := set.Find(q)
+	if err != nil || len(docs) != 1 {
+		t.Fatal(docs, err)
+	}
+	if _, has := docs[0].(map[string]interface{})["password"]; has {
+		t.Fatal(docs[0])
+	}
+}
+
+func TestIter(t *testing.T) {
+	set := cars(t)
+	set.Sort("year")
+	set.Select(map[string]int{"make": 1})
+	c, err := set.Iter(map[string]interface{}{"tags": "german"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	makes := []interface{}{}
+	for {
+		doc, ok := c.Next()
+		if !ok {
+			break
+		}
+		if len(doc) != 2 {
+			t.Fatal(doc)
+		}
+		makes = append(makes, doc["make"])
+	}
+	if err := c.Close(); err != nil || c.Err() != nil {
+		t.Fatal(err, c.Err())
+	}
+	if len(makes) != 2 || makes[0] != "audi" || makes[1] != "bmw" {
+		t.Fatal(makes)
+	}
+}
+
+func TestIterateWhileUpdating(t *testing.T) {
+	set := cars(t)
+	for i := 0; i < 5; i++ {
+		set.Insert(map[string]interface{}{"make": "lada", "year": 1980 + i})
+	}
+	f := filter.New(set, &MockEvent{}, nil)
+	visits := 0
+	err := f.Iterate(&iface.IterOptions{Batch: 3}, func(doc map[string]interface{}, g iface.Grabbed) error {
+		visits++
+		return g.Update(map[string]interface{}{"$set": map[string]interface{}{"seen": true}})
+	})
+	if err != nil || visits != 8 {
+		t.Fatal(visits, err)
+	}
+	if c, _ := set.Count(map[string]interface{}{"seen": true}); c != 8 {
+		t.Fatal(c)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	set := cars(t)
+	agg := iface.Aggregation{
+		By:		[]iface.GroupBy{{Field: "tags"}},
+		Ops:	[]iface.AggOp{{Op: "count"}, {Op: "min", Field: "year"}, {Op: "sum", Field: "year"}},
+	}
+	rows, err := set.Aggregate(map[string]interface{}{"year": map[string]interface{}{"$gt": 2000}}, agg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fiat has no tags.
+	if len(rows) != 3 || rows[0]["tags"] != nil || rows[2]["tags"] != "german" || rows[2]["count"] != 1 || rows[2]["sum_year"] != float64(2001) {
+		t.Fatal(rows)
+	}
+	if _, err := set.Aggregate(nil, iface.Aggregation{Ops: []iface.AggOp{{Op: "mode"}}}); !errs.Is(err, errs.BadInput) {
+		t.Fatal(err)
+	}
+}
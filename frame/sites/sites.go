@@ -0,0 +1,142 @@
+// Package sites makes it possible to serve multiple sites from one process.
+// The sites are registered in the "sites" collection of the main database, a request is routed to the site its host belongs to.
+// Requests coming from unregistered hosts are served by the default site, which lives in the main database itself,
+// so a process serving only one site does not need to know about any of this.
+package sites
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/config"
+	"github.com/opesun/chill/frame/errs"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/storage"
+	"regexp"
+	"strings"
+)
+
+// Possible values of config.TenantMode.
+const(
+	Single	= ""			// Sites are not looked up, every host is served by the default site.
+	DB		= "db"			// Every site has its own database.
+	Prefix	= "prefix"		// The sites share the main database, their collection names are prefixed with the site name.
+)
+
+// Separates the site name from the collection name in Prefix mode. Neither the site names, nor the nouns (which can't
+// contain dots, those separate the keys of the option paths) contain it, so the collections of the sites can't collide with
+// each other, or with the ones of the default site.
+const Separator = "."
+
+// The collection of the sites in the main database.
+const Coll = "sites"
+
+type Site struct {
+	Name		string		// Identifies the site, the names of its database or collections are derived from it.
+	Host		string		// Canonical host.
+	Default		bool
+}
+
+var name_reg = regexp.MustCompile("^[a-z0-9_]+$")
+
+// Names of the databases used by MongoDB itself.
+var reserved = map[string]bool{
+	"admin":	true,
+	"local":	true,
+	"config":	true,
+}
+
+// Checks that a site can be registered with the given name and hosts.
+func Check(set iface.Set, name string, hosts []string) error {
+	if !name_reg.MatchString(name) {
+		return errs.New(errs.Validation, "Site name can only contain lowercase letters, digits and underscores.")
+	}
+	if reserved[name] {
+		return errs.New(errs.Validation, "Site name %v is reserved.", name)
+	}
+	if len(hosts) == 0 {
+		return errs.New(errs.Validation, "Site must have a host.")
+	}
+	c, err := set.Count(map[string]interface{}{"name": name})
+	if err != nil {
+		return err
+	}
+	if c > 0 {
+		return errs.New(errs.Conflict, "Site name %v is already taken.", name)
+	}
+	for _, v := range hosts {
+		site, err := find(set, v)
+		if err != nil {
+			return err
+		}
+		if site != nil {
+			return errs.New(errs.Conflict, "Host %v already belongs to site %v.", v, site["name"])
+		}
+	}
+	return nil
+}
+
+func find(set iface.Set, host string) (map[string]interface{}, error) {
+	set.Limit(1)
+	res, err := set.Find(map[string]interface{}{
+		"$or": []interface{}{
+			map[string]interface{}{"host": host},
+			map[string]interface{}{"aliases": host},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	return res[0].(map[string]interface{}), nil
+}
+
+// Hosts are matched with the port first, then without it.
+func lookup(set iface.Set, host string) (map[string]interface{}, error) {
+	host = strings.ToLower(host)
+	site, err := find(set, host)
+	if err != nil || site != nil {
+		return site, err
+	}
+	if i := strings.LastIndex(host, ":"); i != -1 {
+		return find(set, host[:i])
+	}
+	return nil, nil
+}
+
+// Resolve finds the site of host and returns its storage.
+func Resolve(sess storage.Session, c *config.Config, host string) (*Site, iface.Storage, error) {
+	main := sess.Storage()
+	def := &Site{Host: host, Default: true}
+	if c.TenantMode == Single {
+		return def, main, nil
+	}
+	doc, err := lookup(main.Set(Coll), host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc == nil {
+		return def, main, nil
+	}
+	site := &Site{}
+	site.Name, _ = doc["name"].(string)
+	site.Host, _ = doc["host"].(string)
+	if !name_reg.MatchString(site.Name) || reserved[site.Name] {
+		return nil, nil, fmt.Errorf("Site of host %v has an invalid name.", host)
+	}
+	switch c.TenantMode {
+	case DB:
+		dbs, ok := sess.(storage.Databases)
+		if !ok {
+			return nil, nil, fmt.Errorf("Storage driver %v can't keep sites in separate databases.", c.DBBackend)
+		}
+		st, err := dbs.Database(site.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return site, st, nil
+	case Prefix:
+		return site, storage.Prefixed(main, site.Name + Separator), nil
+	}
+	return nil, nil, fmt.Errorf("Unkown tenant mode %v.", c.TenantMode)
+}
@@ -0,0 +1,84 @@
+package sites_test
+
+import(
+	"github.com/opesun/chill/frame/config"
+	"github.com/opesun/chill/frame/sites"
+	"github.com/opesun/chill/frame/storage"
+	"testing"
+)
+
+func session(t *testing.T, mode string) (storage.Session, *config.Config) {
+	c := &config.Config{DBBackend: "memory", TenantMode: mode}
+	conn, err := storage.Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, _ := conn.Session()
+	set := sess.Storage().Set("sites")
+	err = sites.Check(set, "blog", []string{"blog.com", "www.blog.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Insert(map[string]interface{}{"name": "blog", "host": "blog.com", "aliases": []interface{}{"www.blog.com"}})
+	return sess, c
+}
+
+func TestCheck(t *testing.T) {
+	sess, _ := session(t, sites.DB)
+	set := sess.Storage().Set("sites")
+	bad := []struct{
+		name	string
+		hosts	[]string
+	}{
+		{"Blog!", []string{"other.com"}},
+		{"blog", []string{"other.com"}},
+		{"other", []string{"www.blog.com"}},
+		{"other", nil},
+		{"admin", []string{"other.com"}},
+	}
+	for _, v := range bad {
+		if sites.Check(set, v.name, v.hosts) == nil {
+			t.Fatal(v)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	for _, mode := range []string{sites.DB, sites.Prefix} {
+		sess, c := session(t, mode)
+		site, st, err := sites.Resolve(sess, c, "www.blog.com:8080")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if site.Default || site.Name != "blog" || site.Host != "blog.com" {
+			t.Fatal(mode, site)
+		}
+		st.Set("posts").Insert(map[string]interface{}{"title": "Hello."})
+		def, def_st, err := sites.Resolve(sess, c, "unknown.com")
+		if err != nil || !def.Default {
+			t.Fatal(mode, def, err)
+		}
+		count, _ := def_st.Set("posts").Count(nil)
+		if count != 0 {
+			t.Fatal(mode, count)
+		}
+		// Nor can the collections of the default site collide with the prefixed ones.
+		count, _ = def_st.Set("blog_posts").Count(nil)
+		if count != 0 {
+			t.Fatal(mode, count)
+		}
+		_, again, _ := sites.Resolve(sess, c, "blog.com")
+		count, _ = again.Set("posts").Count(nil)
+		if count != 1 {
+			t.Fatal(mode, count)
+		}
+	}
+}
+
+func TestSingle(t *testing.T) {
+	sess, c := session(t, sites.Single)
+	site, _, err := sites.Resolve(sess, c, "blog.com")
+	if err != nil || !site.Default {
+		t.Fatal(site, err)
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import(
+	"github.com/opesun/chill/frame/config"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/memset"
+	"sync"
+)
+
+func init() {
+	drivers.register("memory", openMemory)
+}
+
+// Nothing is persisted, everything is lost when the process exits. Useful for trying things out and for tests.
+type memoryConn struct {
+	main	*memset.Db
+	mut		sync.Mutex
+	dbs		map[string]*memset.Db
+}
+
+func openMemory(c *config.Config) (Conn, error) {
+	return &memoryConn{main: memset.NewDb(), dbs: map[string]*memset.Db{}}, nil
+}
+
+// memset.Db is safe for concurrent use, so the sessions share the databases.
+func (m *memoryConn) Session() (Session, error) {
+	return &memorySession{m}, nil
+}
+
+func (m *memoryConn) Close() {
+}
+
+type memorySession struct {
+	conn	*memoryConn
+}
+
+func (m *memorySession) Storage() iface.Storage {
+	return &memoryStorage{m.conn.main}
+}
+
+func (m *memorySession) Database(name string) (iface.Storage, error) {
+	m.conn.mut.Lock()
+	defer m.conn.mut.Unlock()
+	db, has := m.conn.dbs[name]
+	if !has {
+		db = memset.NewDb()
+		m.conn.dbs[name] = db
+	}
+	return &memoryStorage{db}, nil
+}
+
+func (m *memorySession) Close() {
+}
+
+type memoryStorage struct {
+	db		*memset.Db
+}
+
+func (m *memoryStorage) Set(coll string) iface.Set {
+	return memset.New(m.db, coll)
+}
+
+func (m *memoryStorage) Options() iface.OptionStore {
+	return NewOptionStore(m.Set("options"))
+}
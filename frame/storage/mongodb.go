@@ -0,0 +1,101 @@
+package storage
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/config"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set"
+	"labix.org/v2/mgo"
+	"time"
+)
+
+func init() {
+	drivers.register("mongodb", openMongo)
+}
+
+// The master session is never used directly, every request gets a copy of it.
+type mongoConn struct {
+	master		*mgo.Session
+	dbName		string
+}
+
+func dialString(c *config.Config) (string, error) {
+	dial := c.DBAddr
+	if len(c.DBUser) != 0 || len(c.DBPass) != 0 {
+		if len(c.DBUser) == 0 {
+			return "", fmt.Errorf("Database password provided but username is missing.")
+		}
+		if len(c.DBPass) == 0 {
+			return "", fmt.Errorf("Database username is provided but password is missing.")
+		}
+		dial = c.DBUser + ":" + c.DBPass + "@" + c.DBAddr
+		if !c.DBAdmMode {
+			dial = dial + "/" + c.DBName
+		}
+	}
+	return dial, nil
+}
+
+func openMongo(c *config.Config) (Conn, error) {
+	dial, err := dialString(c)
+	if err != nil {
+		return nil, err
+	}
+	master, err := mgo.Dial(dial)
+	if err != nil {
+		return nil, err
+	}
+	// The copies inherit these settings.
+	if c.DBSocketTimeout > 0 {
+		master.SetSocketTimeout(time.Duration(c.DBSocketTimeout) * time.Second)
+	}
+	if c.DBSyncTimeout > 0 {
+		master.SetSyncTimeout(time.Duration(c.DBSyncTimeout) * time.Second)
+	}
+	return &mongoConn{master, c.DBName}, nil
+}
+
+// Copies the master session, so the request gets its own socket from the pool. The server is not pinged, that would cost
+// a round trip on every request, a session failing on a broken socket is refreshed instead (see Refresh).
+func (m *mongoConn) Session() (Session, error) {
+	return &mongoSession{m.master.Copy(), m.dbName}, nil
+}
+
+func (m *mongoConn) Close() {
+	m.master.Close()
+}
+
+type mongoSession struct {
+	session		*mgo.Session
+	dbName		string
+}
+
+func (m *mongoSession) Storage() iface.Storage {
+	return &mongoStorage{m.session.DB(m.dbName)}
+}
+
+// The databases of the sites are named after the main one, eg. chill_example.
+func (m *mongoSession) Database(name string) (iface.Storage, error) {
+	return &mongoStorage{m.session.DB(m.dbName + "_" + name)}, nil
+}
+
+// Drops the socket of the session, the next operation gets a fresh one, mgo redials the servers if needed.
+func (m *mongoSession) Refresh() {
+	m.session.Refresh()
+}
+
+func (m *mongoSession) Close() {
+	m.session.Close()
+}
+
+type mongoStorage struct {
+	db		*mgo.Database
+}
+
+func (m *mongoStorage) Set(coll string) iface.Set {
+	return set.New(m.db, coll)
+}
+
+func (m *mongoStorage) Options() iface.OptionStore {
+	return NewOptionStore(m.Set("options"))
+}
@@ -0,0 +1,95 @@
+package storage
+
+import(
+	"database/sql"
+	"github.com/opesun/chill/frame/config"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/set/sqlset"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+func init() {
+	drivers.register("sqlite", openSQLite)
+}
+
+// *sql.DB is a connection pool on its own, safe for concurrent use, so the sessions share the databases.
+type sqliteConn struct {
+	path	string
+	main	*sql.DB
+	mut		sync.Mutex
+	dbs		map[string]*sql.DB		// Databases of the sites, opened lazily.
+}
+
+func openSQLite(c *config.Config) (Conn, error) {
+	path := c.SQLitePath
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.AbsPath, path)
+	}
+	db, err := sqlset.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{path: path, main: db, dbs: map[string]*sql.DB{}}, nil
+}
+
+func (s *sqliteConn) Session() (Session, error) {
+	return &sqliteSession{s}, nil
+}
+
+func (s *sqliteConn) Close() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, v := range s.dbs {
+		v.Close()
+	}
+	s.main.Close()
+}
+
+// Every database is a separate file next to the main one, eg. chill_example.db.
+func (s *sqliteConn) database(name string) (*sql.DB, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if db, has := s.dbs[name]; has {
+		return db, nil
+	}
+	ext := filepath.Ext(s.path)
+	db, err := sqlset.Open(strings.TrimSuffix(s.path, ext) + "_" + name + ext)
+	if err != nil {
+		return nil, err
+	}
+	s.dbs[name] = db
+	return db, nil
+}
+
+type sqliteSession struct {
+	conn	*sqliteConn
+}
+
+func (s *sqliteSession) Storage() iface.Storage {
+	return &sqliteStorage{s.conn.main}
+}
+
+func (s *sqliteSession) Database(name string) (iface.Storage, error) {
+	db, err := s.conn.database(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStorage{db}, nil
+}
+
+func (s *sqliteSession) Close() {
+}
+
+type sqliteStorage struct {
+	db		*sql.DB
+}
+
+func (s *sqliteStorage) Set(coll string) iface.Set {
+	return sqlset.New(s.db, coll)
+}
+
+func (s *sqliteStorage) Options() iface.OptionStore {
+	return NewOptionStore(s.Set("options"))
+}
@@ -0,0 +1,114 @@
+// Package storage decouples the frame from the database drivers.
+// Drivers register themselves by name (see mongodb.go, sqlite.go and memory.go), the one being used is picked by config.DBBackend.
+// Everything above this package sees only the iface.Storage and iface.Set abstractions.
+package storage
+
+import(
+	"fmt"
+	"github.com/opesun/chill/frame/config"
+	iface "github.com/opesun/chill/frame/interfaces"
+)
+
+// A Driver opens a connection. It is called once, at startup.
+type Driver func(*config.Config) (Conn, error)
+
+// Connection of a driver, lives as long as the process.
+type Conn interface {
+	// Called at the start of every request, the returned Session must be closed when the request is served.
+	Session() (Session, error)
+	Close()
+}
+
+// A Session isolates a request from the other ones running concurrently.
+type Session interface {
+	Storage() iface.Storage
+	Close()
+}
+
+// Sessions which can recover from broken connections implement this, see top.New.
+type Refresher interface {
+	Refresh()
+}
+
+// Sessions of the drivers which can keep the sites (see package sites) in separate databases implement this too.
+type Databases interface {
+	Database(name string) (iface.Storage, error)
+}
+
+// Separates the sites sharing one database by prefixing the names of their collections.
+type prefixed struct {
+	storage		iface.Storage
+	prefix		string
+}
+
+func Prefixed(s iface.Storage, prefix string) iface.Storage {
+	return &prefixed{s, prefix}
+}
+
+func (p *prefixed) Set(coll string) iface.Set {
+	return p.storage.Set(p.prefix + coll)
+}
+
+func (p *prefixed) Options() iface.OptionStore {
+	return NewOptionStore(p.Set("options"))
+}
+
+type store map[string]Driver
+
+func (s store) register(name string, d Driver) {
+	s[name] = d
+}
+
+var drivers = store{}
+
+// Register makes a driver available by name. Drivers living outside of this package can register themselves in their init functions.
+func Register(name string, d Driver) {
+	drivers.register(name, d)
+}
+
+func Drivers() []string {
+	ret := []string{}
+	for i := range drivers {
+		ret = append(ret, i)
+	}
+	return ret
+}
+
+// Opens a connection with the driver named in c.DBBackend.
+func Open(c *config.Config) (Conn, error) {
+	name := c.DBBackend
+	if name == "" {
+		name = "mongodb"
+	}
+	d, has := drivers[name]
+	if !has {
+		return nil, fmt.Errorf("Unkown storage driver %v.", name)
+	}
+	return d(c)
+}
+
+// An iface.OptionStore on top of any iface.Set, the option documents are versioned by their "created" field.
+type setOptions struct {
+	set		iface.Set
+}
+
+func NewOptionStore(set iface.Set) iface.OptionStore {
+	return &setOptions{set}
+}
+
+func (s *setOptions) Latest() (map[string]interface{}, error) {
+	s.set.Sort("-created")
+	s.set.Limit(1)
+	res, err := s.set.Find(nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	return res[0].(map[string]interface{}), nil
+}
+
+func (s *setOptions) Insert(doc map[string]interface{}) error {
+	return s.set.Insert(doc)
+}
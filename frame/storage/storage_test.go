@@ -0,0 +1,52 @@
+package storage_test
+
+import(
+	"github.com/opesun/chill/frame/config"
+	"github.com/opesun/chill/frame/storage"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	c := &config.Config{DBBackend: "nonexistent"}
+	_, err := storage.Open(c)
+	if err == nil {
+		t.Fatal()
+	}
+	c.DBBackend = "memory"
+	conn, err := storage.Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sess, err := conn.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	err = sess.Storage().Set("cars").Insert(map[string]interface{}{"make": "bmw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := conn.Session()
+	count, err := other.Storage().Set("cars").Count(nil)
+	if err != nil || count != 1 {
+		t.Fatal(count, err)
+	}
+}
+
+func TestOptionStore(t *testing.T) {
+	conn, _ := storage.Open(&config.Config{DBBackend: "memory"})
+	sess, _ := conn.Session()
+	opts := sess.Storage().Options()
+	latest, err := opts.Latest()
+	if err != nil || latest != nil {
+		t.Fatal(latest, err)
+	}
+	for i := 1; i < 4; i++ {
+		opts.Insert(map[string]interface{}{"created": i})
+	}
+	latest, err = opts.Latest()
+	if err != nil || latest["created"] != 3 {
+		t.Fatal(latest, err)
+	}
+}
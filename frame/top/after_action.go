@@ -6,6 +6,8 @@ import(
 	"fmt"
 	"net/http"
 	"encoding/json"
+	"github.com/opesun/chill/frame/display"
+	"github.com/opesun/chill/frame/errs"
 )
 
 // After running a background operation this either redirects with data in url paramters or prints out the json encoded result.
@@ -15,7 +17,7 @@ func (t *Top) actionResponse(err error, action_name string) {
 		fmt.Println(uni.Req.Referer())
 		fmt.Println("	", err)
 	}
-	_, is_json := uni.Req.Form["json"]
+	is_json := display.WantsJSON(uni)
 	redir := uni.Req.Referer()
 	if red, ok := uni.Dat["redirect"]; ok {
 		redir = red.(string)
@@ -29,13 +31,18 @@ func (t *Top) actionResponse(err error, action_name string) {
 	} else {
 		cont = map[string]interface{}{}
 	}
+	if !is_json {
+		t.formFeedback(err, cont)
+	}
 	redir = appendParams(redir, action_name, err, cont)
 	if is_json {
 		cont["redirect"] = redir
 		if err == nil {
 			cont["ok"] = true
 		} else {
-			cont["error"] = err.Error()
+			env := errs.Envelope(err)["error"].(map[string]interface{})
+			env["request_id"] = errs.RequestId(uni.Req)
+			cont["error"] = env
 		}
 		var v []byte
 		if _, fmt := uni.Req.Form["fmt"]; fmt {
@@ -43,12 +50,35 @@ func (t *Top) actionResponse(err error, action_name string) {
 		} else {
 			v, _ = json.Marshal(cont)
 		}
+		uni.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err != nil {
+			uni.W.WriteHeader(errs.Status(err))
+		}
 		uni.Put(string(v))
 	} else {
 		http.Redirect(uni.W, uni.Req, redir, 303)
 	}
 }
 
+// Puts the field errors and the submitted values into cont, so the form can be displayed again with them, see convert.WithFeedback.
+func (t *Top) formFeedback(err error, cont map[string]interface{}) {
+	fields := errs.FieldsOf(err)
+	if fields == nil {
+		return
+	}
+	for i, v := range fields {
+		cont["err." + i] = v
+	}
+	for i, v := range t.input {
+		if strings.Contains(i, "password") {		// Secrets should not end up in urls.
+			continue
+		}
+		if str, ok := v.(string); ok {
+			cont["val." + i] = str
+		}
+	}
+}
+
 // This writes all necessary information after a background operation into the redirect url, and deletes
 // parts which were when a previous background op ran.
 func appendParams(url_str string, action_name string, err error, cont map[string]interface{}) string {
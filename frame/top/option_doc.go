@@ -2,91 +2,70 @@
 package top
 
 import (
-	"encoding/json"
-	"fmt"
-	"labix.org/v2/mgo"
-	"sync"
-	"time"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/event"
+	"github.com/opesun/chill/frame/indexes"
+	"github.com/opesun/chill/frame/options"
+	"github.com/opesun/chill/frame/storage"
+	"log"
 )
 
-const (
-	cached_opt_inv     = "The cached options string is not a valid JSON." // TODO: Maybe we should try to recover from here.
-	cant_unmarshal     = "Can't unmarshal freshly encoded option document."
-	cant_encode_config = "Can't encode config. - No way this should happen anyway."
-)
+// Shared between the requests. Invalidated when a new option document is saved, see invalidateOnSave.
+var cache = options.NewCache()
 
-// mutex locked map set
-// Puts the JSON encoded string version of the option document to cache.
-func setCache(c map[string]string, key, val string) {
-	mut := new(sync.Mutex)
-	mut.Lock()
-	defer mut.Unlock()
-	c[key] = val
+// Loads the freshest option document of the site, from the cache if cache_it is true.
+// Returns both the map[string]interface{} and a JSON encoded string version too.
+// The string version is being returned to be able to serve a version of the option document which is 100% untampered.
+// (Assuming that the string is stored as private and only a copy of it can be retrieved)
+func queryConfig(opts iface.OptionStore, site string, cache_it bool) (map[string]interface{}, string, error) {
+	if !cache_it {
+		return options.Load(opts)
+	}
+	return cache.Load(opts, site)
 }
 
-// mutex locked map get
-// Gets the JSON encoded string version of the option document from the cache.
-func cacheHas(c map[string]string, str string) (string, bool) {
-	mut := new(sync.Mutex)
-	mut.Lock()
-	defer mut.Unlock()
-	v, ok := c[str]
-	return v, ok
+// Keeps the indexes of the sites in sync with their option documents.
+var reconciler = indexes.NewReconciler()
+
+// Reconciles the indexes of a site when its option document changes. Called on every request, it costs nothing if the
+// indexes are already in sync, and so a failed reconciliation is retried. See reconcileOnSave too.
+// Errors are only logged, a bad index declaration should not take the site down.
+func reconcileIndexes(st iface.Storage, site string, opt map[string]interface{}, opt_str string) {
+	if err := reconciler.Run(st, site, opt, opt_str); err != nil {
+		log.Printf("Can't reconcile the indexes of site %q: %v", site, err)
+	}
 }
 
-// This is an artifact from the old version, we don't need the map here.
-// Leave it as is, we may migrate back to the "multiple http servers from one process" approach. *1
-var cache = make(map[string]string)
+// ReconcileIndexes reconciles the indexes of the default site, to be called at startup.
+// The other sites are reconciled on their first request.
+func ReconcileIndexes(sess storage.Session) error {
+	st := sess.Storage()
+	opt, opt_str, err := options.Load(st.Options())
+	if err != nil {
+		return err
+	}
+	return reconciler.Run(st, "", opt, opt_str)
+}
 
-// Loads the freshest option document from the database, and caches it if cache_it is true.
-// Returns both the map[string]interface{} which comes from the database directly, and a JSON encoded string version too.
-// The string version is being returned to be able to serve a version of the option document which is 100% untampered.
-// (Assuming that the string is stored as private and only a copy of it can be retrieved)
-//
-// The data is also stored in the cache as a string to provide its immutability.
-// (One pageload this way can't mess up the option document for the next.)
-func queryConfig(db *mgo.Database, host string, cache_it bool) (map[string]interface{}, string, error) {
-	host = "anything" // See *1
-	ret := map[string]interface{}{}
-	var ret_str string
-	if val, ok := cacheHas(cache, host); cache_it && ok {
-		ret_str = val
-		var v interface{}
-		json.Unmarshal([]byte(val), &v)
-		if v == nil {
-			return nil, "", fmt.Errorf(cached_opt_inv)
-		}
-		ret = v.(map[string]interface{})
-		delete(ret, "_id")
-	} else {
-		var res []interface{}
-		err := db.C("options").Find(nil).Sort("-created").Limit(1).All(&res)
+// Reconciles the indexes right when a new option document is saved, instead of at the next request.
+func reconcileOnSave(ev *event.Ev, st iface.Storage, site string) {
+	rec := func(...interface{}) {
+		opt, opt_str, err := options.Load(st.Options())
 		if err != nil {
-			return nil, "", err
-		}
-		var fresh_opt interface{}
-		if len(res) == 0 {
-			fresh_opt = m{}
-			db.C("options").Insert(m{"created":time.Now().UnixNano()})		// Intentionally skipping error here.
-		} else {
-			fresh_opt = res[0]
-		}
-		enc, merr := json.Marshal(fresh_opt)
-		if merr != nil {
-			return nil, "", fmt.Errorf(cant_encode_config)
-		}
-		str := string(enc)
-		ret_str = str
-		if cache_it {
-			setCache(cache, host, str)
+			log.Printf("Can't reconcile the indexes of site %q: %v", site, err)
+			return
 		}
-		var v interface{}
-		json.Unmarshal([]byte(str), &v)
-		if v == nil {
-			return nil, "", fmt.Errorf(cant_unmarshal)
-		}
-		ret = v.(map[string]interface{})
-		delete(ret, "_id")
+		reconcileIndexes(st, site, opt, opt_str)
+	}
+	ev.Listen("optionsInserted", rec)
+	ev.Listen("optionsUpdated", rec)
+}
+
+// Every site has its own options collection, only the options of the current site can change.
+func invalidateOnSave(ev *event.Ev, site string) {
+	inv := func(...interface{}) {
+		cache.Invalidate(site)
 	}
-	return ret, ret_str, nil
+	ev.Listen("optionsInserted", inv)
+	ev.Listen("optionsUpdated", inv)
 }
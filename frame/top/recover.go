@@ -0,0 +1,92 @@
+package top
+
+import(
+	"encoding/json"
+	"github.com/opesun/chill/frame/errs"
+	"log"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// Remembers if anything was written already, because after that the status code can't be changed.
+type recWriter struct {
+	http.ResponseWriter
+	wrote	bool
+}
+
+func (w *recWriter) WriteHeader(code int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+func wantsJSON(req *http.Request) bool {
+	ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return ct == "application/json" || strings.Contains(req.Header.Get("Accept"), "application/json") || req.URL.Query()["json"] != nil
+}
+
+// Logs err, and keeps it in errs.Recent if it is a server side failure. The errors caused by the client are not interesting.
+func Report(req *http.Request, err error, stack []byte) {
+	if errs.Status(err) < 500 {
+		return
+	}
+	rep := errs.NewReport(req, err, stack)
+	errs.Recent.Add(rep)
+	if stack != nil {
+		log.Printf("Panic in request %v %v %v%v: %v\n%s", rep.RequestId, rep.Method, rep.Host, rep.Path, rep.Message, stack)
+	} else {
+		log.Printf("Error in request %v %v %v%v: %v", rep.RequestId, rep.Method, rep.Host, rep.Path, rep.Message)
+	}
+}
+
+// Recover wraps the handling of every request. It gives an id to the request, and turns a panic into a 500 response
+// instead of a broken one. The panic message is logged, but it is not shown to the client.
+// http.ErrAbortHandler is passed on to net/http, that is not a failure.
+func Recover(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := errs.RequestId(req)
+		if len(id) == 0 || len(id) > 64 {		// A proxy in front of us may have given an id already.
+			id = errs.NewRequestId()
+			req.Header.Set(errs.RequestIdHeader, id)
+		}
+		w.Header().Set(errs.RequestIdHeader, id)
+		rw := &recWriter{ResponseWriter: w}
+		defer func(){
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {		// Aborting the response on purpose, net/http handles it without logging.
+				panic(r)
+			}
+			Report(req, errs.New(errs.Internal, "%v", r), debug.Stack())
+			if rw.wrote {
+				return
+			}
+			err := errs.New(errs.Internal, "Internal server error, request id: %v.", id)
+			if wantsJSON(req) {
+				env := errs.Envelope(err)
+				env["error"].(map[string]interface{})["request_id"] = id
+				v, _ := json.Marshal(env)
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write(v)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}()
+		h(rw, req)
+	}
+}
+
+// Same as http.Error, but the error is reported too.
+func Error(w http.ResponseWriter, req *http.Request, err error) {
+	Report(req, err, nil)
+	http.Error(w, err.Error(), errs.Status(err))
+}
@@ -0,0 +1,67 @@
+package top_test
+
+import(
+	"encoding/json"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/top"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	h := top.Recover(func(w http.ResponseWriter, req *http.Request) {
+		panic("Malformed id.")
+	})
+	req := httptest.NewRequest("GET", "/cars/xyz?json", nil)
+	w := httptest.NewRecorder()
+	h(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatal(w.Code)
+	}
+	id := w.Header().Get(errs.RequestIdHeader)
+	if id == "" {
+		t.Fatal(w.Header())
+	}
+	var env map[string]map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if env["error"]["request_id"] != id || strings.Contains(w.Body.String(), "Malformed") {
+		t.Fatal(env)
+	}
+	rep := errs.Recent.Reports()[0]
+	if rep.RequestId != id || rep.Message != "Malformed id." || rep.Stack == "" || rep.Path != "/cars/xyz?json" {
+		t.Fatal(rep)
+	}
+}
+
+func TestRecoverKeepsId(t *testing.T) {
+	h := top.Recover(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(errs.RequestId(req)))
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(errs.RequestIdHeader, "abc")
+	w := httptest.NewRecorder()
+	h(w, req)
+	if w.Body.String() != "abc" || w.Header().Get(errs.RequestIdHeader) != "abc" {
+		t.Fatal(w.Body.String(), w.Header())
+	}
+}
+
+func TestRecoverAbort(t *testing.T) {
+	h := top.Recover(func(w http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	before := len(errs.Recent.Reports())
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Fatal(r)
+		}
+		if len(errs.Recent.Reports()) != before {
+			t.Fatal(errs.Recent.Reports())
+		}
+	}()
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -9,18 +9,25 @@ import(
 	"github.com/opesun/chill/frame/misc/convert"
 	"github.com/opesun/chill/frame/display"
 	"github.com/opesun/chill/frame/filter"
-	"github.com/opesun/chill/frame/set"
 	iface "github.com/opesun/chill/frame/interfaces"
 	"github.com/opesun/chill/frame/verbinfo"
 	"github.com/opesun/chill/frame/glue"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/chill/frame/computed"
+	"github.com/opesun/chill/frame/fieldtypes"
+	"github.com/opesun/chill/frame/refs"
+	"github.com/opesun/chill/frame/sites"
+	"github.com/opesun/chill/frame/set/query"
+	"github.com/opesun/chill/frame/storage"
 	"github.com/opesun/jsonp"
 	"github.com/opesun/numcon"
 	"github.com/opesun/sanitize"
 	"net/http"
 	"net/url"
+	"encoding/json"
+	"mime"
 	"fmt"
 	"io"
-	"labix.org/v2/mgo"
 	"strconv"
 	"strings"
 )
@@ -32,12 +39,15 @@ func (t *Top) buildUser() {
 		t.uni.Dat["_user"] = usr
 	}
 	ins := t.uni.NewModule("users").Instance()
-	ins.Method("BuildUser").Call(ret_rec, filter.NewSimple(set.New(t.uni.Db, "users"), t.uni.Ev))
+	ins.Method("BuildUser").Call(ret_rec, filter.NewSimple(t.uni.Storage.Set("users"), t.uni.Ev))
 }
 
 type Top struct{
 	uni 	*context.Uni
 	config 	*config.Config
+	site	*sites.Site
+	json	map[string]interface{}		// Decoded body of application/json requests, nil otherwise.
+	input	map[string]interface{}		// The input data of the verb before validation, sent back to the form if the validation fails.
 }
 
 func burnResults(a map[string]interface{}, key string, b []interface{}) {
@@ -54,32 +64,73 @@ func (t *Top) Get(ret []interface{}) {
 	uni := t.uni
 	ran := verbinfo.NewRanalyzer(ret)
 	if ran.HadError() {
+		Report(uni.Req, ran.Error(), nil)
 		display.DErr(uni, ran.Error())
 		return
 	}
-	burnResults(uni.Dat, "main", ran.NonErrors())
+	res := ran.NonErrors()
+	if err := t.expand(res); err != nil {
+		Report(uni.Req, err, nil)
+		display.DErr(uni, err)
+		return
+	}
+	burnResults(uni.Dat, "main", res)
 	display.D(uni)
 }
 
+// Replaces the ids in the reference fields listed in the expand modifier with the referenced documents, see package refs.
+// Only the results of Get and GetSingle are expanded.
+func (t *Top) expand(res []interface{}) error {
+	uni := t.uni
+	fields := refs.Fields(uni.Modifiers["expand"])
+	if len(fields) == 0 || len(res) == 0 || uni.Sentence == nil {
+		return nil
+	}
+	var docs []map[string]interface{}
+	switch v := res[0].(type) {
+	case map[string]interface{}:
+		docs = append(docs, v)
+	case []interface{}:
+		for _, x := range v {
+			if doc, ok := x.(map[string]interface{}); ok {
+				docs = append(docs, doc)
+			}
+		}
+	default:
+		return nil
+	}
+	rs := refs.Refs(uni.Opt, uni.Sentence.Noun)
+	nouns, err := refs.Nouns(rs, fields)
+	if err != nil {
+		return err
+	}
+	forbidden := map[string][]string{}
+	for _, v := range nouns {
+		if err := t.allowed(v, "Get"); err != nil {
+			return err
+		}
+		f, _ := jsonp.GetS(uni.Opt, fmt.Sprintf("nouns.%v.forbidden_fields", v))
+		forbidden[v] = stringList(f)
+	}
+	return refs.Expand(uni.Storage, docs, rs, fields, forbidden)
+}
+
 func (t *Top) Post(ret []interface{}) {
 	uni := t.uni
 	ran := verbinfo.NewRanalyzer(ret)
 	var err error
 	if ran.HadError() {
 		err = ran.Error()
+		Report(uni.Req, err, nil)
 	}
 	t.actionResponse(err, uni.Sentence.Verb)
 }
 
+// Panics are not recovered here, see Recover.
 func (t *Top) Route() {
-	defer func(){
-		if r := recover(); r != nil {
-			t.uni.Put(fmt.Sprint(r))
-			panic(fmt.Sprint(r))
-		}
-	}()
 	err := t.route()
 	if err != nil {
+		Report(t.uni.Req, err, nil)
 		display.DErr(t.uni, err)
 		return
 	}
@@ -89,6 +140,43 @@ var opt_def = map[string]interface{}{
 	"composed_of": []interface{}{"jsonedit"},
 }
 
+// Only the admins of the default site can manage the sites.
+var sites_def = map[string]interface{}{
+	"composed_of": []interface{}{"sites"},
+	"verbs": map[string]interface{}{
+		"Get": map[string]interface{}{
+			"level": 300,
+		},
+		"GetSingle": map[string]interface{}{
+			"level": 300,
+		},
+		"New": map[string]interface{}{
+			"level": 300,
+		},
+		"Insert": map[string]interface{}{
+			"level": 300,
+			"input": map[string]interface{}{
+				"name": 1,
+				"host": 1,
+				"aliases": map[string]interface{}{
+					"slice": true,
+					"type": "string",
+				},
+			},
+		},
+	},
+}
+
+// The errors of the whole process are listed, so only the admins of the default site can see them.
+var errors_def = map[string]interface{}{
+	"composed_of": []interface{}{"errlog"},
+	"verbs": map[string]interface{}{
+		"Get": map[string]interface{}{
+			"level": 300,
+		},
+	},
+}
+
 func (t *Top) validate(noun, verb string, data map[string]interface{}) (map[string]interface{}, error) {
 	scheme_map, ok := jsonp.GetM(t.uni.Opt, fmt.Sprintf("nouns.%v.verbs.%v.input", noun, verb))
 	if !ok {
@@ -99,16 +187,119 @@ func (t *Top) validate(noun, verb string, data map[string]interface{}) (map[stri
 		return nil, err
 	}
 	t.uni.Ev.Fire("SanitizerMangler", ex)
-	data, err = ex.Extract(data)
+	extracted, err := ex.Extract(data)
 	if err != nil {
-		return nil, err
+		return nil, t.fieldErrors(scheme_map, data, err)
 	}
+	data = extracted
 	t.uni.Ev.Fire("SanitizedDataMangler", data)
+	if comp, ok := jsonp.GetM(t.uni.Opt, fmt.Sprintf("nouns.%v.verbs.%v.computed", noun, verb)); ok {
+		user, _ := t.uni.Dat["_user"].(map[string]interface{})
+		err = computed.Apply(comp, data, user)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return data, nil
 }
 
-func filterCreator(db *mgo.Database, ev iface.Event, nouns, input map[string]interface{}, c string) iface.Filter {
-	return filter.New(set.New(db, c), ev, input)
+// Extract stops at the first invalid field, so to be able to tell about every one of them, the fields are checked one by one.
+// Returns the original error if the problem can't be tied to any field.
+func (t *Top) fieldErrors(scheme, data map[string]interface{}, err error) error {
+	fields := errs.Fields{}
+	for key, sch := range scheme {
+		ex, e := sanitize.New(map[string]interface{}{key: sch})
+		if e != nil {
+			continue
+		}
+		t.uni.Ev.Fire("SanitizerMangler", ex)
+		inp := map[string]interface{}{}
+		if v, has := data[key]; has {
+			inp[key] = v
+		}
+		if _, e = ex.Extract(inp); e != nil {
+			fields[key] = e.Error()
+		}
+	}
+	if len(fields) == 0 {
+		return errs.Wrap(errs.Validation, err)
+	}
+	return fields
+}
+
+func filterCreator(storage iface.Storage, ev iface.Event, nouns, input map[string]interface{}, c string) iface.Filter {
+	allowed := filter.Allowed{}
+	fm, _ := jsonp.GetM(nouns, c + ".filters")
+	for i, v := range fm {
+		allowed[i], _ = v.(string)
+	}
+	defaults, _ := jsonp.GetS(nouns, c + ".default_fields")
+	forbidden, _ := jsonp.GetS(nouns, c + ".forbidden_fields")
+	f := filter.NewAllowed(storage.Set(c), ev, input, allowed, schemeFields(nouns, c))
+	return f.Project(stringList(defaults), stringList(forbidden)).Relate(relations(nouns))
+}
+
+// Collects the relations declared at nouns.X.relations, see filter.Relations.
+func relations(nouns map[string]interface{}) filter.Relations {
+	ret := filter.Relations{}
+	for noun, v := range nouns {
+		rels, _ := jsonp.GetM(v, "relations")
+		for field, to := range rels {
+			if s, ok := to.(string); ok {
+				if ret[noun] == nil {
+					ret[noun] = map[string]string{}
+				}
+				ret[noun][field] = s
+			}
+		}
+	}
+	return ret
+}
+
+func stringList(a []interface{}) []string {
+	ret := []string{}
+	for _, v := range a {
+		if s, ok := v.(string); ok {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// The fields appearing in the input schemes of the verbs of noun, those can be queried by equality.
+// The hidden and forbidden fields are left out, or they could be guessed by querying.
+func schemeFields(nouns map[string]interface{}, noun string) []string {
+	forbidden, _ := jsonp.GetS(nouns, noun + ".forbidden_fields")
+	left_out := map[string]bool{}
+	for _, v := range append(stringList(forbidden), query.Hidden...) {
+		left_out[v] = true
+	}
+	ret := []string{}
+	verbs, _ := jsonp.GetM(nouns, noun + ".verbs")
+	for _, v := range verbs {
+		vm, _ := v.(map[string]interface{})
+		input, _ := vm["input"].(map[string]interface{})
+		for i := range input {
+			if !left_out[i] {
+				ret = append(ret, i)
+			}
+		}
+	}
+	return ret
+}
+
+// Checks if the current user has the level required to call verb of noun.
+func (t *Top) allowed(noun, verb string) error {
+	default_level, _ := numcon.Int(t.uni.Opt["default_level"])
+	levi, ok := jsonp.Get(t.uni.Opt, fmt.Sprintf("nouns.%v.verbs.%v.level", noun, verb))
+	if !ok {
+		levi = default_level
+	}
+	lev, _ := numcon.Int(levi)
+	if scut.Ulev(t.uni.Dat["_user"]) < lev {
+		return errs.New(errs.Forbidden, "Not allowed.")
+	}
+	return nil
 }
 
 func (t *Top) route() error {
@@ -132,28 +323,48 @@ func (t *Top) route() error {
 	if _, ok := nouns["options"]; !ok {
 		nouns["options"] = opt_def
 	}
+	if _, ok := nouns["sites"]; !ok && t.site.Default && t.config.TenantMode != sites.Single {
+		nouns["sites"] = sites_def
+	}
+	if _, ok := nouns["_errors"]; !ok && t.site.Default {
+		nouns["_errors"] = errors_def
+	}
+	uni.Opt["nouns"] = nouns		// So the schemes of the default nouns can be found too.
 	uni.FilterCreator = func(c string, input map[string]interface{}) iface.Filter {
-		return filterCreator(uni.Db, uni.Ev, nouns, input, c)
+		return filterCreator(uni.Storage, uni.Ev, nouns, input, c)
 	}
 	desc, err := glue.Identify(uni.Path, nouns, convert.Mapify(uni.Req.Form))
 	if err != nil {
 		display.D(uni)
 		return nil
 	}
-	default_level, _ := numcon.Int(uni.Opt["default_level"])
-	levi, ok := jsonp.Get(uni.Opt, fmt.Sprintf("nouns.%v.verbs.%v.level", desc.Sentence.Noun, desc.Sentence.Verb))
-	if !ok {
-		levi = default_level
+	if verb := glue.MethodVerb(uni.Req.Method, desc.Sentence); verb != "" {
+		desc, err = glue.IdentifyWithVerb(uni.Path, nouns, t.urlQuery(), t.body(), verb)
+		if err != nil {
+			return err
+		}
+	} else if t.json != nil && desc.Sentence.Verb != "Get" && desc.Sentence.Verb != "GetSingle" {
+		desc.Route.Queries[len(desc.Route.Queries)-1] = t.json		// The input data of the verb, see glue.CreateInputs.
 	}
-	lev, _ := numcon.Int(levi)
-	if scut.Ulev(uni.Dat["_user"]) < lev {
-		return fmt.Errorf("Not allowed.")
+	module := t.uni.NewModule(desc.VerbLocation)
+	if !module.Exists() {
+		return errs.New(errs.NotFound, "Unkown module.")
+	}
+	ins := module.Instance()
+	err = glue.AllowedMethod(uni.Req.Method, desc.Sentence, nouns, ins)
+	if err != nil {
+		return err
+	}
+	err = t.allowed(desc.Sentence.Noun, desc.Sentence.Verb)
+	if err != nil {
+		return err
 	}
 	inp, data, err := desc.CreateInputs(uni.FilterCreator)
 	if err != nil {
 		return err
 	}
 	if data != nil {
+		t.input = data
 		if desc.Sentence.Noun != "options" {
 			data, err = t.validate(desc.Sentence.Noun, desc.Sentence.Verb, data)
 			if err != nil {
@@ -164,11 +375,6 @@ func (t *Top) route() error {
 	}
 	uni.Route = desc.Route
 	uni.Sentence = desc.Sentence
-	module := t.uni.NewModule(desc.VerbLocation)
-	if !module.Exists() {
-		return fmt.Errorf("Unkown module.")
-	}
-	ins := module.Instance()
 	ins.Method(uni.Sentence.Verb).Call(ret_rec, inp...)
 	if uni.Req.Method == "GET" {
 		uni.Dat["main_noun"] = desc.Sentence.Noun
@@ -180,9 +386,56 @@ func (t *Top) route() error {
 	return nil
 }
 
+func copyValues(a url.Values) url.Values {
+	ret := url.Values{}
+	for i, v := range a {
+		ret[i] = append([]string{}, v...)
+	}
+	return ret
+}
+
+// The url query, without the modifiers.
+func (t *Top) urlQuery() map[string]interface{} {
+	q := t.uni.Req.URL.Query()
+	modifiers(q)
+	return convert.Mapify(q)
+}
+
+// The body of the request, without the modifiers.
+func (t *Top) body() map[string]interface{} {
+	if t.json != nil {
+		return t.json
+	}
+	b := copyValues(t.uni.Req.PostForm)
+	modifiers(b)
+	return convert.Mapify(b)
+}
+
+// Decodes the body of application/json requests, the values keep their JSON types.
+// Returns nil if the request has an other content type.
+func jsonBody(req *http.Request) (map[string]interface{}, error) {
+	ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if ct != "application/json" || req.Body == nil {
+		return nil, nil
+	}
+	var v interface{}
+	err := json.NewDecoder(io.LimitReader(req.Body, 1000000)).Decode(&v)
+	if err != nil && err != io.EOF {
+		return nil, errs.New(errs.BadInput, "Request body is not a valid JSON: %v", err)
+	}
+	if v == nil {
+		return map[string]interface{}{}, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errs.New(errs.BadInput, "Request body must be a JSON object.")
+	}
+	return m, nil
+}
+
 // Strips information unrelated to verb input from the Form.
 func modifiers(a url.Values) map[string]interface{} {
-	flags := []string{"json", "src", "nofmt", "ok", "action"}
+	flags := []string{"json", "src", "nofmt", "ok", "action", "expand"}
 	mods := map[string]interface{}{}
 	for _, v := range flags {
 		if val, has := a[v]; has {
@@ -199,12 +452,39 @@ func modifiers(a url.Values) map[string]interface{} {
 	return mods
 }
 
-func New(session *mgo.Session, db *mgo.Database, w http.ResponseWriter, req *http.Request, config *config.Config) (t *Top, err error) {
+// The site of the request and its options. These are the first reads of a request, so if they fail (eg. on a socket broken
+// by a restart of the database server), the session is refreshed and they are retried once.
+func siteOf(sess storage.Session, config *config.Config, host string) (*sites.Site, iface.Storage, map[string]interface{}, string, error) {
+	var err error
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			r, ok := sess.(storage.Refresher)
+			if !ok {
+				break
+			}
+			r.Refresh()
+		}
+		var site *sites.Site
+		var st iface.Storage
+		site, st, err = sites.Resolve(sess, config, host)
+		if err != nil {
+			continue
+		}
+		var opt map[string]interface{}
+		var opt_str string
+		opt, opt_str, err = queryConfig(st.Options(), site.Name, config.CacheOpt)
+		if err == nil {
+			return site, st, opt, opt_str, nil
+		}
+	}
+	return nil, nil, nil, "", err
+}
+
+func New(sess storage.Session, w http.ResponseWriter, req *http.Request, config *config.Config) (t *Top, err error) {
 	put := func(a ...interface{}) {
 		io.WriteString(w, fmt.Sprint(a...)+"\n")
 	}
 	uni := &context.Uni{
-		Db:      	db,
 		W:       	w,
 		Req:     	req,
 		Put:     	put,
@@ -213,27 +493,43 @@ func New(session *mgo.Session, db *mgo.Database, w http.ResponseWriter, req *htt
 		Path:       req.URL.Path,
 		NewModule:	mod.NewModule,
 	}
-	err = uni.Req.ParseMultipartForm(1000000)		// Should we handle the error return of this?
-	if err != nil {
+	err = uni.Req.ParseMultipartForm(1000000)
+	if err != nil && err != http.ErrNotMultipart {		// Not every request comes with a multipart body.
 		return nil, err
 	}
 	mods := modifiers(uni.Req.Form)
 	uni.Modifiers = mods
-	// Not sure if not giving the db session to nonadmin installations increases security, but hey, one can never be too cautious, they dont need it anyway.
-	if config.DBAdmMode {
-		uni.Session = session
+	body, err := jsonBody(req)
+	if err != nil {
+		return nil, err
+	}
+	site, st, opt, opt_str, err := siteOf(sess, config, req.Host)
+	if err != nil {
+		return nil, err
 	}
-	opt, opt_str, err := queryConfig(uni.Db, req.Host, config.CacheOpt) // Tricky part about the host, see comments at main_model.
+	uni.Storage = st
+	uni.MainStorage = sess.Storage()
+	uni.DefaultSite = site.Default
+	reconcileIndexes(st, site.Name, opt, opt_str)
+	uni.Req.Host, err = scut.Host(req.Host, opt)
 	if err != nil {
 		return nil, err
 	}
-	uni.Req.Host = scut.Host(req.Host, opt)
+	if _, has := opt["canonical_host"]; !has && !site.Default {
+		uni.Req.Host = site.Host
+	}
 	uni.Opt = opt
 	hooks, _ := uni.Opt["Hooks"].(map[string]interface{})
 	ev := event.New(uni, hooks, mod.NewModule)
+	invalidateOnSave(ev, site.Name)
+	reconcileOnSave(ev, st, site.Name)
+	ev.Listen("SanitizerMangler", func(a ...interface{}) {
+		fieldtypes.Add(a[0].(*sanitize.Extractor))
+		refs.Add(a[0].(*sanitize.Extractor))
+	})
 	uni.Ev = ev
 	uni.NewModule = ev.NewModuleProducer()
 	uni.SetOriginalOpt(opt_str)
 	uni.SetSecret(config.Secret)
-	return &Top{uni,config}, nil
+	return &Top{uni, config, site, body, nil}, nil
 }
\ No newline at end of file
@@ -1,53 +1,46 @@
 package main
 
 import (
-	"labix.org/v2/mgo"
 	"net/http"
 	"fmt"
 	"github.com/opesun/chill/frame/top"
 	"github.com/opesun/chill/frame/config"
+	"github.com/opesun/chill/frame/storage"
 )
 
-func err() {
-	if r := recover(); r != nil {
-		fmt.Println(r)
-		panic(fmt.Sprint(r))
-	}
-}
-
 func main() {
-	defer err()
 	fmt.Println("Starting server.")
 	config := config.New()
 	config.LoadFromFile()
-	dial := config.DBAddr
-	if len(config.DBUser) != 0 || len(config.DBPass) != 0 {
-		if len(config.DBUser) == 0 {
-			panic("Database password provided but username is missing.")
-		}
-		if len(config.DBPass) == 0 {
-			panic("Database username is provided but password is missing.")
-		}
-		dial = config.DBUser + ":" + config.DBPass + "@" + config.DBAddr
-		if !config.DBAdmMode {
-			dial = dial + "/" + config.DBName
-		}
+	conn, err := storage.Open(config)
+	if err != nil {
+		panic(err)
 	}
-	session, err := mgo.Dial(dial)
+	defer conn.Close()
+	sess, err := conn.Session()
 	if err != nil {
 		panic(err)
 	}
-	db := session.DB(config.DBName)
-	defer session.Close()
-	http.HandleFunc("/",
+	err = top.ReconcileIndexes(sess)
+	sess.Close()
+	if err != nil {
+		fmt.Println("Can't reconcile the indexes:", err)
+	}
+	http.HandleFunc("/", top.Recover(
 	func(w http.ResponseWriter, req *http.Request) {
-		t, err := top.New(session, db, w, req, config)
+		sess, err := conn.Session()
+		if err != nil {
+			top.Error(w, req, err)
+			return
+		}
+		defer sess.Close()
+		t, err := top.New(sess, w, req, config)
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			top.Error(w, req, err)
 			return
 		}
 		t.Route()
-	})
+	}))
 	err = http.ListenAndServe(config.Addr+":"+config.PortNum, nil)
 	if err != nil {
 		fmt.Println(err)
@@ -0,0 +1,30 @@
+// Package errlog shows the recent failures of the process to the admins, see errs.Recent.
+package errlog
+
+import(
+	"github.com/opesun/chill/frame/context"
+	"github.com/opesun/chill/frame/errs"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/misc/scut"
+)
+
+type C struct {
+	uni *context.Uni
+}
+
+func (c *C) Init(uni *context.Uni) {
+	c.uni = uni
+}
+
+// The read only verbs, see glue.AllowedMethod.
+func (c *C) SafeVerbs() []string {
+	return []string{"Get"}
+}
+
+// The reports are kept in memory, the filter is not used.
+func (c *C) Get(a iface.Filter) ([]errs.Report, error) {
+	if !scut.IsAdmin(c.uni.Dat["_user"]) {
+		return nil, errs.New(errs.Forbidden, "Only an admin can do this operation.")
+	}
+	return errs.Recent.Reports(), nil
+}
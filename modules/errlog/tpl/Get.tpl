@@ -0,0 +1,18 @@
+{{require header.t}}
+
+<h1>Recent errors:</h1>
+{{if .main}}
+	{{range .main}}
+		<div>
+			<b>{{.Status}}</b> {{.Method}} {{.Host}}{{.Path}} <span class="date">{{.Time}}</span><br />
+			Request id: {{.RequestId}}<br />
+			{{.Message}}
+			{{if .Stack}}<pre>{{.Stack}}</pre>{{end}}
+		</div>
+		<br />
+	{{end}}
+{{else}}
+	No errors since the start of the process.
+{{end}}
+
+{{require footer.t}}
\ No newline at end of file
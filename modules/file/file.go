@@ -2,6 +2,7 @@ package file
 
 import(
 	"github.com/opesun/chill/frame/context"
+	"github.com/opesun/chill/frame/errs"
 	"github.com/opesun/jsonp"
 	"github.com/opesun/chill/frame/misc/convert"
 	iface "github.com/opesun/chill/frame/interfaces"
@@ -29,15 +30,20 @@ func (c *C) Init(uni *context.Uni) {
 	c.fileBiz = map[string]interface{}{} 
 }
 
+// The read only verbs, see glue.AllowedMethod.
+func (c *C) SafeVerbs() []string {
+	return append(c.Basics.SafeVerbs(), "New", "Edit")
+}
+
 func (c *C) SanitizerMangler(san *sanitize.Extractor) {
 	san.AddFuncs(sanitize.FuncMap{
 		"file": func(dat interface{}, s sanitize.Scheme) (interface{}, error) {
-			if c.uni.Req.MultipartForm.File == nil {
-				return nil, fmt.Errorf("No files at all.")
+			if c.uni.Req.MultipartForm == nil || c.uni.Req.MultipartForm.File == nil {
+				return nil, errs.New(errs.BadInput, "No files at all.")
 			}
 			val, has := c.uni.Req.MultipartForm.File[s.Key]
 			if !has {
-				return nil, fmt.Errorf("Can't find key amongst files.")
+				return nil, errs.New(errs.BadInput, "Can't find key amongst files.")
 			}
 			ret := []interface{}{}
 			for _, v := range val {
@@ -216,7 +222,11 @@ func (c *C) New(a iface.Filter) ([]map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	return convert.SchemeToFields(scheme, nil)
+	fields, err := convert.SchemeToFields(scheme, nil)
+	if err != nil {
+		return nil, err
+	}
+	return convert.WithFeedback(fields, c.uni.Modifiers), nil
 }
 
 func (c *C) Edit(a iface.Filter) ([]map[string]interface{}, error) {
@@ -228,5 +238,9 @@ func (c *C) Edit(a iface.Filter) ([]map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	return convert.SchemeToFields(scheme, doc)
+	fields, err := convert.SchemeToFields(scheme, doc)
+	if err != nil {
+		return nil, err
+	}
+	return convert.WithFeedback(fields, c.uni.Modifiers), nil
 }
\ No newline at end of file
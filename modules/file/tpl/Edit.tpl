@@ -11,14 +11,30 @@
 			<input type="file" name="{{.key}}" multiple="multiple"/><br />	<!-- !!! -->
 			<br />
 			{{range .value}}
-				{{.}} <a href="/{{url "delete-file" "key" $key "file" .}}">Delete</a><br />
+				{{.}}<br />
 			{{end}}
 		{{else}}
-			<input name="{{$f.KeyPrefix}}{{.key}}" value="{{.value}}"/><br />
+			{{require skeleton/field.t}}
 		{{end}}
+		{{if .error}}<span class="error">{{.error}}</span><br />{{end}}
 		<br />
 	{{end}}
 	<input type="submit" />
 </form>
 
+{{$d := form "delete-file"}}
+{{range .main}}
+	{{$key := .key}}
+	{{if eq .type "file"}}
+		{{range .value}}
+			<form action="/{{$d.ActionPath}}" method="POST">
+				{{$d.HiddenString}}
+				<input type="hidden" name="{{$d.KeyPrefix}}key" value="{{$key}}"/>
+				<input type="hidden" name="{{$d.KeyPrefix}}file" value="{{.}}"/>
+				{{$key}}: {{.}} <input type="submit" value="Delete"/>
+			</form>
+		{{end}}
+	{{end}}
+{{end}}
+
 {{require footer.t}}
\ No newline at end of file
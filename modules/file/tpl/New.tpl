@@ -9,8 +9,9 @@
 		{{if eq .type "file"}}
 			<input type="file" name="{{.key}}" multiple="multiple"/><br />	<!-- !!! -->
 		{{else}}
-			<input name="{{$f.KeyPrefix}}{{.key}}"/><br />
+			{{require skeleton/field.t}}
 		{{end}}
+		{{if .error}}<span class="error">{{.error}}</span><br />{{end}}
 		<br />
 	{{end}}
 	<input type="submit" />
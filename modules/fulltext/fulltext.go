@@ -3,6 +3,7 @@ package fulltext
 import(
 	iface "github.com/opesun/chill/frame/interfaces"
 	"github.com/opesun/slugify"
+	"log"
 	"labix.org/v2/mgo/bson"
 	"strings"
 )
@@ -92,7 +93,12 @@ func (c *C) RegenerateFulltext(a iface.Filter) error {
 		upd := c.updateFromDoc(doc)
 		return g.Update(upd)
 	}
-	return a.Iterate(cb)
+	opts := &iface.IterOptions{
+		Progress: func(done, total int) {
+			log.Printf("Regenerating fulltext of %v: %v/%v.", a.Subject(), done, total)
+		},
+	}
+	return a.Iterate(opts, cb)
 }
 
 func GenerateKeywords(s string) []string {
@@ -0,0 +1,80 @@
+package jsonedit
+
+import(
+	"github.com/opesun/chill/frame/set/query"
+	"sort"
+	"strconv"
+)
+
+// One difference between two versions.
+type Change struct {
+	Path	string			// Dotted path of the field, eg. "nouns.cars.verbs".
+	Kind	string			// "added", "removed" or "changed".
+	From	interface{}
+	To		interface{}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func diffVal(path string, a, b interface{}, ret []Change) []Change {
+	am, a_map := a.(map[string]interface{})
+	bm, b_map := b.(map[string]interface{})
+	if a_map && b_map {
+		return diffMap(path, am, bm, ret)
+	}
+	as, a_sl := a.([]interface{})
+	bs, b_sl := b.([]interface{})
+	if a_sl && b_sl && len(as) == len(bs) {
+		for i := range as {
+			ret = diffVal(join(path, strconv.Itoa(i)), as[i], bs[i], ret)
+		}
+		return ret
+	}
+	if !query.Equal(a, b) {
+		ret = append(ret, Change{path, "changed", a, b})
+	}
+	return ret
+}
+
+func diffMap(path string, a, b map[string]interface{}, ret []Change) []Change {
+	keys := []string{}
+	for i := range a {
+		keys = append(keys, i)
+	}
+	for i := range b {
+		if _, has := a[i]; !has {
+			keys = append(keys, i)
+		}
+	}
+	sort.Strings(keys)
+	for _, v := range keys {
+		av, in_a := a[v]
+		bv, in_b := b[v]
+		p := join(path, v)
+		switch {
+		case !in_a:
+			ret = append(ret, Change{p, "added", nil, bv})
+		case !in_b:
+			ret = append(ret, Change{p, "removed", av, nil})
+		default:
+			ret = diffVal(p, av, bv, ret)
+		}
+	}
+	return ret
+}
+
+// Structured diff of two option documents, the fields describing the versions themselves are left out.
+func diff(older, newer map[string]interface{}) []Change {
+	a := query.CopyMap(older)
+	b := query.CopyMap(newer)
+	for _, v := range ignore {
+		delete(a, v)
+		delete(b, v)
+	}
+	return diffMap("", a, b, []Change{})
+}
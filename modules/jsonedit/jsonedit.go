@@ -5,8 +5,12 @@ import(
 	"github.com/opesun/chill/frame/composables/basics"
 	iface "github.com/opesun/chill/frame/interfaces"
 	"github.com/opesun/chill/frame/context"
+	"github.com/opesun/chill/frame/errs"
+	"github.com/opesun/jsonp"
+	"labix.org/v2/mgo/bson"
+	"github.com/opesun/chill/frame/options"
+	"github.com/opesun/chill/frame/set/query"
 	"time"
-	"fmt"
 )
 
 type C struct {
@@ -16,19 +20,65 @@ type C struct {
 
 func (c *C) Init(uni *context.Uni) {
 	c.uni = uni
+	c.Basics.Ev = uni.Ev
+}
+
+// The read only verbs, see glue.AllowedMethod.
+func (c *C) SafeVerbs() []string {
+	return append(c.Basics.SafeVerbs(), "New", "Edit", "Versions", "Diff")
 }
 
 func (c *C) decrypt(data map[string]interface{}) (map[string]interface{}, error) {
+	if m, ok := data["json"].(map[string]interface{}); ok {		// Coming from an application/json request body.
+		return m, nil
+	}
 	jsond, ok := data["json"].(string)
 	if !ok {
-		return nil, fmt.Errorf("Member json is nonexistent or not a string.")
+		return nil, errs.New(errs.BadInput, "Member json is nonexistent or not a string.")
 	}
 	var v interface{}
 	err := json.Unmarshal([]byte(jsond), &v)
 	if err != nil {
-		return nil, err
+		return nil, errs.Wrap(errs.BadInput, err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errs.New(errs.BadInput, "Member json must be an object.")
+	}
+	return m, nil
+}
+
+// A broken option document would take the whole site down, so it is checked before saving.
+func (c *C) validate(a iface.Filter, m map[string]interface{}) error {
+	if a.Subject() != "options" {
+		return nil
 	}
-	return v.(map[string]interface{}), nil
+	return options.Validate(m, c.uni.NewModule)
+}
+
+// Every insert is a new version, the newest one is in effect (see package options).
+func (c *C) insert(a iface.Filter, m map[string]interface{}) error {
+	err := c.validate(a, m)
+	if err != nil {
+		return err
+	}
+	m["created"] = time.Now().UnixNano()
+	if user_id, ok := jsonp.Get(c.uni.Dat, "_user._id"); ok {
+		m["created_by"] = user_id
+	}
+	id := bson.NewObjectId()
+	m["_id"] = id
+	err = a.Insert(m)
+	if err != nil {
+		return err
+	}
+	if c.Ev != nil {
+		// A fresh filter, the one of a Rollback selects the old version.
+		filt := c.uni.FilterCreator(a.Subject(), map[string]interface{}{"id": id})
+		c.Ev.Fire("Inserted", filt)
+		c.Ev.Fire(a.Subject() + "Inserted", filt)
+	}
+	return nil
 }
 
 func (c *C) Insert(a iface.Filter, data map[string]interface{}) error {
@@ -36,8 +86,7 @@ func (c *C) Insert(a iface.Filter, data map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
-	m["created"] = time.Now().UnixNano()	// Should include user too maybe.
-	return a.Insert(m)
+	return c.insert(a, m)
 }
 
 func (c *C) Update(a iface.Filter, data map[string]interface{}) error {
@@ -45,15 +94,30 @@ func (c *C) Update(a iface.Filter, data map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
+	err = c.validate(a, m)
+	if err != nil {
+		return err
+	}
 	m["modified"] = time.Now().UnixNano()
-	return a.Update(m)
+	if user_id, ok := jsonp.Get(c.uni.Dat, "_user._id"); ok {
+		m["modified_by"] = user_id
+	}
+	err = a.Update(m)
+	if err != nil {
+		return err
+	}
+	if c.Ev != nil {
+		c.Ev.Fire("Updated", a)
+		c.Ev.Fire(a.Subject() + "Updated", a)
+	}
+	return nil
 }
 
 func (c *C) New() error {
 	return nil
 }
 
-var ignore = []string{"_id", "created", "modified"}
+var ignore = []string{"_id", "created", "modified", "created_by", "modified_by", "rollback_of"}
 
 func (c *C) Edit(a iface.Filter) (string, error) {
 	doc, err := a.FindOne()
@@ -68,4 +132,95 @@ func (c *C) Edit(a iface.Filter) (string, error) {
 		return "", err
 	}
 	return string(marsh), nil
-}
\ No newline at end of file
+}
+
+// Lists the versions, newest first. Only the fields describing the versions are returned.
+func (c *C) Versions(a iface.Filter) ([]interface{}, error) {
+	docs, err := c.uni.FilterCreator(a.Subject(), map[string]interface{}{
+		"sort":		"-created",
+		"skip":		a.Modifiers().Skip(),
+		"limit":	a.Modifiers().Limit(),
+	}).Find()
+	if err != nil {
+		return nil, err
+	}
+	ret := []interface{}{}
+	for _, v := range docs {
+		doc := v.(map[string]interface{})
+		meta := map[string]interface{}{}
+		for _, x := range ignore {
+			if val, has := doc[x]; has {
+				meta[x] = val
+			}
+		}
+		ret = append(ret, meta)
+	}
+	return ret, nil
+}
+
+// Returns the version created right before doc, nil if doc is the first one.
+func (c *C) previous(subject string, doc map[string]interface{}) (map[string]interface{}, error) {
+	filt := c.uni.FilterCreator(subject, map[string]interface{}{"sort": "-created", "limit": 1})
+	docs, err := filt.AddQuery(map[string]interface{}{
+		"created": map[string]interface{}{
+			"$lt": doc["created"],
+		},
+	}).Find()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	return docs[0].(map[string]interface{}), nil
+}
+
+// Diffs two versions, the older one against the newer one.
+// With only one version selected, it is diffed against its predecessor.
+func (c *C) Diff(a iface.Filter) ([]Change, error) {
+	docs, err := a.Find()
+	if err != nil {
+		return nil, err
+	}
+	var older, newer map[string]interface{}
+	switch len(docs) {
+	case 1:
+		newer = docs[0].(map[string]interface{})
+		older, err = c.previous(a.Subject(), newer)
+		if err != nil {
+			return nil, err
+		}
+		if older == nil {
+			older = map[string]interface{}{}
+		}
+	case 2:
+		older = docs[0].(map[string]interface{})
+		newer = docs[1].(map[string]interface{})
+		if query.Compare(older["created"], newer["created"]) > 0 {
+			older, newer = newer, older
+		}
+	default:
+		return nil, errs.New(errs.BadInput, "Select one or two versions to diff.")
+	}
+	return diff(older, newer), nil
+}
+
+// Rollback reinserts an old version as the newest one.
+func (c *C) Rollback(a iface.Filter) error {
+	ids, err := a.Ids()
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return errs.New(errs.BadInput, "Select exactly one version to roll back to.")
+	}
+	doc, err := a.FindOne()
+	if err != nil {
+		return err
+	}
+	for _, v := range ignore {
+		delete(doc, v)
+	}
+	doc["rollback_of"] = ids[0]
+	return c.insert(a, doc)
+}
@@ -0,0 +1,19 @@
+{{require header.t}}
+
+<h1>{{$.main_noun}} / Diff:</h1>
+{{if .main}}
+	<table>
+		{{range .main}}
+			<tr class="{{.Kind}}">
+				<td>{{.Kind}}</td>
+				<td>{{.Path}}</td>
+				<td>{{.From}}</td>
+				<td>{{.To}}</td>
+			</tr>
+		{{end}}
+	</table>
+{{else}}
+	No differences.
+{{end}}
+
+{{require footer.t}}
\ No newline at end of file
@@ -1,7 +1,7 @@
 {{require header.t}}
 
 <h1>{{$.main_noun}} / New:</h1>
-{{$f := form "update"}}
+{{$f := form "insert"}}
 <form action="/{{$f.ActionPath}}" method="POST">
 	{{$f.HiddenString}}
 	<input type="submit">
@@ -1,6 +1,7 @@
 {{require header.t}}
 
 <h1>{{$.main_noun}} / Get:</h1>
+<a href="/{{$.main_noun}}/versions">Versions</a><br />
 {{if .main}}
 	{{range .main}}
 		<a href="/{{$.main_noun}}/{{._id}}">{{._id}} <span class="date">{{.created}}</span></a><br />
@@ -0,0 +1,22 @@
+{{require header.t}}
+
+<h1>{{$.main_noun}} / Versions:</h1>
+{{if .main}}
+	{{range .main}}
+		<div>
+			<a href="/{{$.main_noun}}/{{._id}}">{{._id}}</a> <span class="date">{{.created}}</span>
+			{{if .created_by}}by {{.created_by}}{{end}}
+			{{if .modified}}(modified <span class="date">{{.modified}}</span>{{if .modified_by}} by {{.modified_by}}{{end}}){{end}}
+			{{if .rollback_of}}(rollback of <a href="/{{$.main_noun}}/{{.rollback_of}}">{{.rollback_of}}</a>){{end}}
+			<a href="/{{$.main_noun}}/{{._id}}/diff">diff</a>
+			<form action="/{{$.main_noun}}/{{._id}}/rollback" method="POST" style="display: inline">
+				<input type="submit" value="Roll back to this">
+			</form>
+		</div>
+		<br />
+	{{end}}
+{{else}}
+	No {{$.main_noun}} yet.
+{{end}}
+
+{{require footer.t}}
\ No newline at end of file
@@ -0,0 +1,79 @@
+// Package sites lets the admins of the default site register new sites, see frame/sites.
+package sites
+
+import(
+	"github.com/opesun/chill/frame/composables/basics"
+	"github.com/opesun/chill/frame/context"
+	"github.com/opesun/chill/frame/errs"
+	iface "github.com/opesun/chill/frame/interfaces"
+	"github.com/opesun/chill/frame/misc/convert"
+	"github.com/opesun/chill/frame/misc/scut"
+	"github.com/opesun/chill/frame/sites"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+type C struct {
+	basics.Basics
+	uni *context.Uni
+}
+
+func (c *C) Init(uni *context.Uni) {
+	c.uni = uni
+	c.Basics.Ev = uni.Ev
+}
+
+// The read only verbs, see glue.AllowedMethod.
+func (c *C) SafeVerbs() []string {
+	return append(c.Basics.SafeVerbs(), "New")
+}
+
+func (c *C) New() error {
+	return nil
+}
+
+// Registers a site. The new site starts empty, just like a fresh install.
+// The sites are looked up in the main database (see sites.Resolve), so they are saved there, whatever noun a is.
+func (c *C) Insert(a iface.Filter, data map[string]interface{}) (bson.ObjectId, error) {
+	if !scut.IsAdmin(c.uni.Dat["_user"]) {
+		return "", errs.New(errs.Forbidden, "Only an admin can do this operation.")
+	}
+	if !c.uni.DefaultSite {
+		return "", errs.New(errs.Forbidden, "Sites can only be registered on the default site.")
+	}
+	name, _ := data["name"].(string)
+	host, _ := data["host"].(string)
+	name = strings.ToLower(strings.TrimSpace(name))
+	host = strings.ToLower(strings.TrimSpace(host))
+	hosts := []string{}
+	if host != "" {
+		hosts = append(hosts, host)
+	}
+	aliases := []string{}
+	if al, ok := data["aliases"].([]interface{}); ok {
+		for _, v := range convert.ToStringSlice(al...) {
+			v = strings.ToLower(strings.TrimSpace(v))
+			if v != "" {
+				aliases = append(aliases, v)
+			}
+		}
+	}
+	set := c.uni.MainStorage.Set(sites.Coll)
+	err := sites.Check(set, name, append(hosts, aliases...))
+	if err != nil {
+		return "", err
+	}
+	id := bson.NewObjectId()
+	err = set.Insert(map[string]interface{}{
+		"_id": id,
+		"name": name,
+		"host": host,
+		"aliases": aliases,
+		"created": time.Now().UnixNano(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
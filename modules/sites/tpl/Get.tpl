@@ -0,0 +1,14 @@
+{{require header.t}}
+
+<h1>{{$.main_noun}} / Get:</h1>
+<a href="/{{$.main_noun}}/new">New site</a><br />
+{{if .main}}
+	{{range .main}}
+		<a href="/{{$.main_noun}}/{{._id}}">{{.name}}</a> {{.host}} {{range .aliases}}{{.}} {{end}}<span class="date">{{.created}}</span><br />
+		<br />
+	{{end}}
+{{else}}
+	No {{$.main_noun}} yet.
+{{end}}
+
+{{require footer.t}}
\ No newline at end of file
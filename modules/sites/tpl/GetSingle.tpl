@@ -0,0 +1,10 @@
+{{require header.t}}
+
+<h1>{{$.main_noun}} / GetSingle:</h1>
+{{with .main}}
+	Name: {{.name}}<br />
+	Host: <a href="http://{{.host}}">{{.host}}</a><br />
+	Aliases: {{range .aliases}}{{.}} {{end}}<br />
+{{end}}
+
+{{require footer.t}}
\ No newline at end of file
@@ -0,0 +1,18 @@
+{{require header.t}}
+
+<h1>{{$.main_noun}} / New:</h1>
+{{$f := form "insert"}}
+<form action="/{{$f.ActionPath}}" method="POST">
+	{{$f.HiddenString}}
+	Name<br />
+	<input name="{{$f.KeyPrefix}}name"/><br />
+	Host<br />
+	<input name="{{$f.KeyPrefix}}host"/><br />
+	Aliases<br />
+	<input name="{{$f.KeyPrefix}}aliases"/><br />
+	<input name="{{$f.KeyPrefix}}aliases"/><br />
+	<br />
+	<input type="submit" />
+</form>
+
+{{require footer.t}}
\ No newline at end of file
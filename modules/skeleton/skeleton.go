@@ -18,6 +18,11 @@ func (c *C) Init(uni *context.Uni) {
 	c.uni = uni
 }
 
+// The read only verbs, see glue.AllowedMethod.
+func (c *C) SafeVerbs() []string {
+	return append(c.Basics.SafeVerbs(), "New", "Edit")
+}
+
 func (c *C) getScheme(noun, verb string) (map[string]interface{}, error) {
 	scheme, ok := jsonp.GetM(c.uni.Opt, fmt.Sprintf("nouns.%v.verbs.%v.input", noun, verb))
 	if !ok {
@@ -31,7 +36,11 @@ func (c *C) New(a iface.Filter) ([]map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	return convert.SchemeToFields(scheme, nil)
+	fields, err := convert.SchemeToFields(scheme, nil)
+	if err != nil {
+		return nil, err
+	}
+	return convert.WithFeedback(fields, c.uni.Modifiers), nil
 }
 
 func (c *C) Edit(a iface.Filter) ([]map[string]interface{}, error) {
@@ -43,5 +52,9 @@ func (c *C) Edit(a iface.Filter) ([]map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	return convert.SchemeToFields(scheme, doc)
+	fields, err := convert.SchemeToFields(scheme, doc)
+	if err != nil {
+		return nil, err
+	}
+	return convert.WithFeedback(fields, c.uni.Modifiers), nil
 }
\ No newline at end of file
@@ -0,0 +1,23 @@
+{{require header.t}}
+
+<h1>{{$.main_noun}} / Aggregate:</h1>
+{{if .main}}
+	<table>
+		<tr>
+		{{range .main1.Columns}}
+			<th>{{.}}</th>
+		{{end}}
+		</tr>
+		{{range $row := .main}}
+			<tr>
+			{{range $.main1.Columns}}
+				<td>{{index $row .}}</td>
+			{{end}}
+			</tr>
+		{{end}}
+	</table>
+{{else}}
+	Nothing to aggregate.
+{{end}}
+
+{{require footer.t}}
\ No newline at end of file
@@ -6,7 +6,8 @@
 	{{$f.HiddenString}}
 	{{range .main}}
 		{{.key}}<br />
-		<input name="{{$f.KeyPrefix}}{{.key}}" value="{{.value}}"/><br />
+		{{require skeleton/field.t}}
+		{{if .error}}<span class="error">{{.error}}</span><br />{{end}}
 		<br />
 	{{end}}
 	<input type="submit" />
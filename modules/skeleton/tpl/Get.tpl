@@ -6,6 +6,11 @@
 		<a href="/{{$.main_noun}}/{{._id}}">{{fallback .title .name ._id}}</a><br />
 		<br />
 	{{end}}
+	{{with .main1}}
+		{{$nav := cursors .Prev .Next}}
+		{{if $nav.Prev}}<a href="{{$nav.Prev}}">Previous</a>{{end}}
+		{{if $nav.Next}}<a href="{{$nav.Next}}">Next</a>{{end}}
+	{{end}}
 {{else}}
 	No {{$.main_noun}} yet.
 {{end}}
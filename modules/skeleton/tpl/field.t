@@ -0,0 +1,14 @@
+{{if eq .input "select"}}
+	{{$val := .value}}
+	<select name="{{$f.KeyPrefix}}{{.key}}">
+		{{range .options}}
+			<option{{if eq (printf "%v" .) (printf "%v" $val)}} selected="selected"{{end}}>{{.}}</option>
+		{{end}}
+	</select><br />
+{{else if eq .input "checkbox"}}
+	<input type="checkbox" name="{{$f.KeyPrefix}}{{.key}}" value="true"{{if .value}} checked="checked"{{end}}/><br />
+{{else if eq .input "number"}}
+	<input type="number" step="any" name="{{$f.KeyPrefix}}{{.key}}" value="{{.value}}"{{if not (eq .min nil)}} min="{{.min}}"{{end}}{{if not (eq .max nil)}} max="{{.max}}"{{end}}{{if .required}} required="required"{{end}}/><br />
+{{else}}
+	<input type="{{.input}}" name="{{$f.KeyPrefix}}{{.key}}" value="{{.value}}"{{if .pattern}} pattern="{{.pattern}}"{{end}}{{if .required}} required="required"{{end}}/><br />
+{{end}}
\ No newline at end of file
@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"github.com/opesun/chill/frame/errs"
 	iface "github.com/opesun/chill/frame/interfaces"
 	"github.com/opesun/chill/frame/misc/convert"
 	"github.com/opesun/slugify"
@@ -26,12 +27,7 @@ type m map[string]interface{}
 // Finds a user by id.
 func FindUser(a iface.Filter, id bson.ObjectId) (map[string]interface{}, error) {
 	q := m{"_id": id}
-	v, err := a.AddQuery(q).FindOne()
-	if err != nil {
-		return nil, err
-	}
-	delete(v, "password")
-	return v, nil
+	return a.AddQuery(q).FindOne()
 }
 
 // Finds he user by name password equality.
@@ -129,6 +125,9 @@ func DecryptId(cookieval string, block_key []byte) (bson.ObjectId, error) {
 	if err != nil {
 		return "", err
 	}
+	if !bson.IsObjectIdHex(str) {
+		return "", errs.New(errs.BadInput, "Malformed user id.")
+	}
 	return bson.ObjectIdHex(str), nil
 }
 
@@ -175,11 +174,17 @@ func RegisterUser(a iface.Filter, user map[string]interface{}) (bson.ObjectId, e
 	if _, has := user["level"]; !has {
 		user["level"] = 100
 	}
+	if name, ok := user["name"].(string); ok && name != "" {
+		user["slug"] = slugify.S(name)		// Kept unique by the users.slug index, see package indexes.
+	}
 	user_id := bson.NewObjectId()
 	user["_id"] = user_id
 	err := a.Insert(user)
 	if err != nil {
-		return "", fmt.Errorf("Name is not unique.")
+		if _, has := errs.FieldsOf(err)["slug"]; has {
+			return "", errs.AlreadyTaken("name")
+		}
+		return "", err
 	}
 	return user_id, nil
 }
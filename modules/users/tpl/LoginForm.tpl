@@ -4,7 +4,10 @@
 
 {{if logged_in}}
 	{{$f := form "logout"}}
-	<a href="/{{$f.ActionPath}}">Logout</a>
+	<form action="/{{$f.ActionPath}}" method="POST">
+		{{$f.HiddenString}}
+		<input type="submit" value="Logout">
+	</form>
 {{else}}
 	{{$f := form "login"}}
 	<form action="/{{$f.ActionPath}}" method="POST">
@@ -5,10 +5,10 @@ package users
 
 import (
 	"github.com/opesun/chill/frame/context"
+	"github.com/opesun/chill/frame/errs"
 	iface "github.com/opesun/chill/frame/interfaces"
 	"github.com/opesun/chill/modules/users/model"
 	"net/http"
-	"fmt"
 	"labix.org/v2/mgo/bson"
 )
 
@@ -20,6 +20,11 @@ func (c *C) Init(uni *context.Uni) {
 	c.uni = uni
 }
 
+// The read only verbs, see glue.AllowedMethod.
+func (c *C) SafeVerbs() []string {
+	return []string{"New", "LoginForm", "NewAdmin"}
+}
+
 // Recover from wrong ObjectId like panics. Unset the cookie.
 func unsetCookie(w http.ResponseWriter) {
 	r := recover()
@@ -79,13 +84,12 @@ func hasAdmin(f iface.Filter) error {
 	q := map[string]interface{}{
 		"level": 300,
 	}
-	f.AddQuery(q)
-	c, err := f.Count()
+	c, err := f.AddQuery(q).Count()
 	if err != nil {
 		return err
 	}
 	if c > 0 {
-		return fmt.Errorf("Site already has an admin.")
+		return errs.New(errs.Conflict, "Site already has an admin.")
 	}
 	return nil
 }
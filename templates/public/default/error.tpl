@@ -0,0 +1,10 @@
+<h1>{{.error.status}}</h1>
+<p>{{.error.message}}</p>
+{{if .error.request_id}}<p>Request id: {{.error.request_id}}</p>{{end}}
+{{if .error.fields}}
+	<ul>
+		{{range $field, $msg := .error.fields}}
+			<li>{{$field}}: {{$msg}}</li>
+		{{end}}
+	</ul>
+{{end}}
\ No newline at end of file